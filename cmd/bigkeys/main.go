@@ -0,0 +1,604 @@
+package main
+
+/*
+ * キーごとの型、要素数(文字列は長さ)、メモリ使用量をTSVで出力し、型ごとの上位N件を集計する。
+ * キーは入力ファイル、SCAN(全マスタ)、RANDOMKEYのいずれかから取る。
+ *
+ * SCANの場合、--stateを指定するとマスタごとのカーソルと途中までの集計を記録し、
+ * 中断しても同じ--stateで再実行すれば続きから処理して、全体を集計する。
+ * このときTSVは実行ごとに、まだない番号のファイル1つに書く(--out-splitは使わない)。
+ * 中断時に処理中だったキーは、次の実行のTSVにも出ることがある。
+ */
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+type keyInfo struct {
+	Key    string `json:"key"`
+	Type   string `json:"type"`
+	Size   int64  `json:"size"`
+	Memory int64  `json:"memory"`
+}
+
+// topHeap 上位N件を残すための最小ヒープ
+type topHeap struct {
+	items []keyInfo
+	less  func(a, b keyInfo) bool
+}
+
+func (h *topHeap) Len() int           { return len(h.items) }
+func (h *topHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *topHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *topHeap) Push(x interface{}) { h.items = append(h.items, x.(keyInfo)) }
+func (h *topHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+type typeStat struct {
+	Count       uint64
+	TotalSize   int64
+	TotalMemory int64
+	top         *topHeap
+}
+
+type report struct {
+	n     int
+	less  func(a, b keyInfo) bool
+	types map[string]*typeStat
+}
+
+func newReport(n int, less func(a, b keyInfo) bool) *report {
+	return &report{
+		n:     n,
+		less:  less,
+		types: map[string]*typeStat{},
+	}
+}
+
+func (r *report) stat(typ string) *typeStat {
+	st, ok := r.types[typ]
+	if !ok {
+		st = &typeStat{top: &topHeap{less: r.less}}
+		r.types[typ] = st
+	}
+	return st
+}
+
+func (r *report) pushTop(st *typeStat, info keyInfo) {
+	if r.n <= 0 {
+		return
+	}
+	if st.top.Len() < r.n {
+		heap.Push(st.top, info)
+	} else if r.less(st.top.items[0], info) {
+		st.top.items[0] = info
+		heap.Fix(st.top, 0)
+	}
+}
+
+func (r *report) add(info keyInfo) {
+	st := r.stat(info.Type)
+	st.Count++
+	st.TotalSize += info.Size
+	st.TotalMemory += info.Memory
+	r.pushTop(st, info)
+}
+
+// toJSON 型ごとの集計を、型の名前順に並べる
+func (r *report) toJSON() []jsonType {
+	out := make([]jsonType, 0, len(r.types))
+	for _, typ := range sortedTypes(r) {
+		st := r.types[typ]
+		out = append(out, jsonType{
+			Type:        typ,
+			Keys:        st.Count,
+			TotalSize:   st.TotalSize,
+			TotalMemory: st.TotalMemory,
+			Top:         st.topItems(r.less),
+		})
+	}
+	return out
+}
+
+// load toJSONで保存した集計を加える
+func (r *report) load(saved []jsonType) {
+	for _, jt := range saved {
+		st := r.stat(jt.Type)
+		st.Count += jt.Keys
+		st.TotalSize += jt.TotalSize
+		st.TotalMemory += jt.TotalMemory
+		for _, info := range jt.Top {
+			r.pushTop(st, info)
+		}
+	}
+}
+
+func (r *report) merge(o *report) {
+	for typ, ost := range o.types {
+		st := r.stat(typ)
+		st.Count += ost.Count
+		st.TotalSize += ost.TotalSize
+		st.TotalMemory += ost.TotalMemory
+		for _, info := range ost.top.items {
+			r.pushTop(st, info)
+		}
+	}
+}
+
+// topItems 大きい順に並べた上位N件
+func (st *typeStat) topItems(less func(a, b keyInfo) bool) []keyInfo {
+	items := append([]keyInfo{}, st.top.items...)
+	sort.Slice(items, func(i, j int) bool { return less(items[j], items[i]) })
+	return items
+}
+
+var lessFuncs = map[string]func(a, b keyInfo) bool{
+	"size":   func(a, b keyInfo) bool { return a.Size < b.Size },
+	"memory": func(a, b keyInfo) bool { return a.Memory < b.Memory },
+}
+
+type reportOption struct {
+	Samples int
+	Batch   int
+	Top     int
+	Less    func(a, b keyInfo) bool
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	mode := flag.String("mode", "scan", "How to get keys when no files specified {scan|random}")
+	samples := flag.Uint64("samples", 0, "Number of keys to sample(0=all scanned keys in scan mode)")
+	scanRate := flag.Float64("scan-rate", 1, "Fraction of scanned keys to be sampled in scan mode")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	match := flag.String("match", "", "Pattern of keys to scan in scan mode")
+	top := flag.Int("top", 10, "Number of biggest keys to report per type")
+	by := flag.String("by", "size", "Rank keys by {size=length or number of elements|memory}")
+	memSamples := flag.Int("memory-samples", 5, "Number of nested values sampled by MEMORY USAGE SAMPLES(0=all)")
+	format := flag.String("format", "text", "Format of summary {text|json}")
+	out := flag.String("out", "bigkeys-", "path/to/prefix-of-file- for TSV of all keys")
+	outSplit := flag.Uint("out-split", 5, "Number of output files")
+	compress := flag.String("compress", "none", "{gzip|none=without compression}")
+	batch := flag.Int("batch", 100, "Number of keys to inspect in a pipeline")
+	worker := flag.Uint("worker", 8, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	state := flag.String("state", "", "File to record scan cursors and partial summary of each master in scan mode. Resume from it if the file exists")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if len(files) == 0 {
+		switch *mode {
+		case "random":
+			if *samples == 0 {
+				log.Fatalf("*** --samples must be >= 1 in random mode")
+			}
+		case "scan":
+			if *scanRate <= 0 || *scanRate > 1 {
+				log.Fatalf("*** --scan-rate must be in (0, 1]")
+			}
+		default:
+			log.Fatalf("*** Unknown --mode: %s", *mode)
+		}
+	}
+
+	if *state != "" {
+		if len(files) > 0 || *mode != "scan" {
+			log.Fatalf("*** --state can be used only in scan mode")
+		}
+		// 打ち切った位置を再開時に決められない
+		if *samples > 0 {
+			log.Fatalf("*** --state can not be used with --samples")
+		}
+	}
+
+	if *memSamples < 0 {
+		log.Fatalf("*** --memory-samples must be >= 0")
+	}
+
+	less, ok := lessFuncs[*by]
+	if !ok {
+		log.Fatalf("*** Unknown --by: %s", *by)
+	}
+
+	if *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("*** Unknown --format: %s", *format)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *batch <= 0 {
+		log.Fatalf("*** --batch must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	opt := reportOption{
+		Samples: *memSamples,
+		Batch:   *batch,
+		Top:     *top,
+		Less:    less,
+	}
+
+	if *state != "" {
+		scanWithState(conn, redisutil.ScanArgs{Match: *match, Count: *scanCount}, *scanRate, *state, *out, *compress, *format, opt)
+		return
+	}
+
+	chOut := make(chan string, *outSplit)
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	wgOut := redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+
+	ctx := context.Background()
+	var lineCount int64
+	if len(files) > 0 {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	} else {
+		go func() {
+			defer close(chLine)
+			client := conn.NewClient()
+			defer client.Close()
+
+			var err error
+			if *mode == "random" {
+				lineCount, err = redisutil.SampleRandomKeys(ctx, client, *samples, chLine)
+			} else {
+				lineCount, _, err = redisutil.SampleScanKeys(ctx, client,
+					redisutil.ScanArgs{Match: *match, Count: *scanCount}, *scanRate, *samples, chLine)
+			}
+			if err != nil {
+				log.Fatalf("*** Sampling: %v", err)
+			}
+		}()
+	}
+
+	chResult := make(chan redisutil.Result, *worker)
+	chReport := make(chan *report, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- bigkeys(ctx, index, conn, chLine, chOut, chReport, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	totalReport := newReport(*top, less)
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+		totalReport.merge(<-chReport)
+	}
+
+	close(chOut)
+	wgOut.Wait()
+
+	if *format == "json" {
+		writeJSON(totalReport)
+	} else {
+		writeText(totalReport)
+	}
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+}
+
+func sortedTypes(r *report) []string {
+	types := make([]string, 0, len(r.types))
+	for typ := range r.types {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func writeText(r *report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "type\tkeys\ttotal size\ttotal memory\t")
+	for _, typ := range sortedTypes(r) {
+		st := r.types[typ]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n", typ, st.Count, st.TotalSize, st.TotalMemory)
+	}
+
+	for _, typ := range sortedTypes(r) {
+		fmt.Fprintf(w, "\nTop %s\tsize\tmemory\t\n", typ)
+		for _, info := range r.types[typ].topItems(r.less) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t\n", info.Key, info.Size, info.Memory)
+		}
+	}
+}
+
+type jsonType struct {
+	Type        string    `json:"type"`
+	Keys        uint64    `json:"keys"`
+	TotalSize   int64     `json:"total_size"`
+	TotalMemory int64     `json:"total_memory"`
+	Top         []keyInfo `json:"top"`
+}
+
+func writeJSON(r *report) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r.toJSON()); err != nil {
+		panic(err)
+	}
+}
+
+// sizeCmd 型に応じて要素数(文字列は長さ)を取るコマンドを積む
+func sizeCmd(ctx context.Context, pipe redis.Pipeliner, typ string, key string) *redis.IntCmd {
+	switch typ {
+	case "string":
+		return pipe.StrLen(ctx, key)
+	case "list":
+		return pipe.LLen(ctx, key)
+	case "set":
+		return pipe.SCard(ctx, key)
+	case "zset":
+		return pipe.ZCard(ctx, key)
+	case "hash":
+		return pipe.HLen(ctx, key)
+	case "stream":
+		return pipe.XLen(ctx, key)
+	default:
+		return nil
+	}
+}
+
+// inspect キーをpipelineでTYPE、次に要素数とMEMORY USAGEを調べてrepに加え、
+// {key}\t{type}\t{size}\t{memory}の行を返す
+func inspect(ctx context.Context, client redis.UniversalClient, keys []string, opt reportOption, rep *report, result *redisutil.Result) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	pipe := client.Pipeline()
+	typeCmds := make([]*redis.StatusCmd, 0, len(keys))
+	for _, key := range keys {
+		typeCmds = append(typeCmds, pipe.Type(ctx, key))
+	}
+	// 個別のエラーは各cmdで見る
+	_, _ = pipe.Exec(ctx)
+
+	infos := make([]keyInfo, 0, len(keys))
+	sizeCmds := make([]*redis.IntCmd, 0, len(keys))
+	memCmds := make([]*redis.IntCmd, 0, len(keys))
+	for j, cmd := range typeCmds {
+		typ, err := cmd.Result()
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		} else if typ == "none" {
+			result.AddError("Key does not exist")
+			continue
+		}
+		infos = append(infos, keyInfo{Key: keys[j], Type: typ})
+		sizeCmds = append(sizeCmds, sizeCmd(ctx, pipe, typ, keys[j]))
+		memCmds = append(memCmds, pipe.MemoryUsage(ctx, keys[j], opt.Samples))
+	}
+	_, _ = pipe.Exec(ctx)
+
+	lines := make([]string, 0, len(infos))
+	for j := range infos {
+		info := infos[j]
+		if sizeCmds[j] != nil {
+			n, err := sizeCmds[j].Result()
+			if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+			info.Size = n
+		}
+		n, err := memCmds[j].Result()
+		if err != nil && err != redis.Nil {
+			result.AddError(err.Error())
+			continue
+		}
+		info.Memory = n
+
+		rep.add(info)
+		lines = append(lines, info.Key+"\t"+info.Type+"\t"+
+			strconv.FormatInt(info.Size, 10)+"\t"+strconv.FormatInt(info.Memory, 10))
+	}
+	return lines
+}
+
+// bigkeys キーをBatch件ずつinspectして、TSVの行をchOutに、集計結果をchReportに送る
+func bigkeys(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, chReport chan<- *report, opt reportOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+	rep := newReport(opt.Top, opt.Less)
+
+	keys := make([]string, 0, opt.Batch)
+	flush := func() {
+		for _, line := range inspect(ctx, client, keys, opt, rep, &result) {
+			chOut <- line
+		}
+		keys = keys[:0]
+	}
+
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]bigkeys: %d\n", i, lc)
+		}
+
+		keys = append(keys, key)
+		if len(keys) >= opt.Batch {
+			flush()
+		}
+	}
+	flush()
+
+	fmt.Fprintf(os.Stderr, "[%02d]bigkeys: %d, Elapsed: %s\n", i, lc, time.Since(from))
+
+	result.Lines = lc
+	chReport <- rep
+
+	return result
+}
+
+// newOutFile 前の実行のTSVを上書きしないよう、まだない番号のファイル名を返す
+func newOutFile(out string, compress string) string {
+	ext := redisutil.GetCompressionType(compress).Ext
+	for n := 0; ; n++ {
+		fn := fmt.Sprintf("%s%03d", out, n)
+		if _, err := os.Stat(fn + ext); os.IsNotExist(err) {
+			return fn
+		}
+	}
+}
+
+// scanWithState 全マスタをSCANしてキーを調べ、SCAN1回分ごとにTSVをSyncしてから、カーソルと集計をstateに保存する
+// マスタごとに並行して処理する
+func scanWithState(conn redisutil.Connection, args redisutil.ScanArgs, rate float64,
+	state string, out string, compress string, format string, opt reportOption) {
+	from := time.Now()
+
+	scanState, err := redisutil.LoadScanState(state)
+	if err != nil {
+		log.Fatalf("*** --state: %v", err)
+	}
+	args.State = scanState
+
+	totalReport := newReport(opt.Top, opt.Less)
+	if len(scanState.Data) > 0 {
+		var saved []jsonType
+		if err := json.Unmarshal(scanState.Data, &saved); err != nil {
+			log.Fatalf("*** --state: %v", err)
+		}
+		totalReport.load(saved)
+	}
+
+	w, err := redisutil.CreateSyncWriter(newOutFile(out, compress), compress)
+	if err != nil {
+		log.Fatalf("*** Create output: %v", err)
+	}
+
+	ctx := context.Background()
+	client := conn.NewClient()
+	defer client.Close()
+
+	totalResult := redisutil.NewResult()
+	mu := &sync.Mutex{}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	err = redisutil.ScanNodeBatches(ctx, client, args, func(addr string, keys []string, cursor uint64) error {
+		var sampled []string
+		mu.Lock()
+		for _, key := range keys {
+			if rate < 1 && r.Float64() >= rate {
+				continue
+			}
+			sampled = append(sampled, key)
+		}
+		mu.Unlock()
+
+		rep := newReport(opt.Top, opt.Less)
+		result := redisutil.NewResult()
+		var lines []string
+		for i := 0; i < len(sampled); i += opt.Batch {
+			end := i + opt.Batch
+			if end > len(sampled) {
+				end = len(sampled)
+			}
+			lines = append(lines, inspect(ctx, client, sampled[i:end], opt, rep, &result)...)
+		}
+		result.Lines = uint64(len(sampled))
+
+		// TSVと集計が、保存するカーソルと食い違わないようにする
+		mu.Lock()
+		defer mu.Unlock()
+		if err := w.WriteLines(lines); err != nil {
+			return err
+		}
+		totalReport.merge(rep)
+		totalResult = totalResult.Combine(result)
+		return scanState.SetWithData(addr, cursor, totalReport.toJSON())
+	})
+	if err != nil {
+		log.Fatalf("*** Scan: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatalf("*** Close output: %v", err)
+	}
+
+	if format == "json" {
+		writeJSON(totalReport)
+	} else {
+		writeText(totalReport)
+	}
+
+	fmt.Fprintf(os.Stderr, "Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+}
@@ -0,0 +1,332 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ *
+ * 入力行は{src}\t{dst}か{src}のみ。{src}のみなら書き換え規則を適用し、規則がなければ同じキー名にする。
+ * ファイルを指定しなければ全マスタをSCANしたキーをコピーする。
+ * --dst-nodeを省略すると同じインスタンス内でCOPYし、指定するとDUMP/RESTOREでコピーする。
+ * --nodeは最初に--src-nodeの代わりに使っていたフラグで、--src-nodeがなければコピー元にする。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+type copyOption struct {
+	Src redisutil.Connection
+	Dst redisutil.Connection
+	// --dst-nodeを省略した場合。COPYを使う
+	SameInstance bool
+	// nilなら入力行の2列目、なければ同じキー名をdstにする
+	Rewriter *redisutil.KeyRewriter
+	Replace  bool
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	replace := flag.Bool("replace", false, "Replace existing destination keys")
+	fromPrefix := flag.String("from-prefix", "", "Prefix of keys to be replaced")
+	toPrefix := flag.String("to-prefix", "", "New prefix of keys")
+	regex := flag.String("regex", "", "Regular expression of keys to be rewritten")
+	repl := flag.String("repl", "", "Replacement for --regex. $1 etc. refer to submatches(ex. user:{$1}:$2)")
+	match := flag.String("match", "", "Pattern of keys to scan when no files specified(default: --from-prefix*)")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	var src, dst redisutil.Connection
+	src.RegisterPrefixedFlags(flag.CommandLine, "src-", "Source ")
+	dst.RegisterPrefixedFlags(flag.CommandLine, "dst-", "Destination ")
+	var nodes redisutil.StrSlice
+	flag.Var(&nodes, "node", "Same as --src-node. Ignored if --src-node is specified")
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(src.Nodes) == 0 {
+		src.Nodes = nodes
+	}
+	if len(src.Nodes) == 0 {
+		src.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := src.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	sameInstance := len(dst.Nodes) == 0
+	if sameInstance {
+		// --src-nodeのURIのDBを引き継ぐと--dst-dbと衝突するので取り除き、--dst-dbがなければ元と同じDBにする
+		dst.Nodes = redisutil.StripNodeDB(src.Nodes)
+		dst.MasterName = src.MasterName
+		dst.SentinelPassword = src.SentinelPassword
+		dstDBSet := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "dst-db" {
+				dstDBSet = true
+			}
+		})
+		if !dstDBSet {
+			dst.DB = src.DB
+		}
+	}
+
+	if err := dst.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	opt := copyOption{
+		Src:          src,
+		Dst:          dst,
+		SameInstance: sameInstance,
+		Replace:      *replace,
+	}
+
+	var err error
+	if *regex != "" {
+		if *fromPrefix != "" {
+			log.Fatalf("*** --regex can not be used with --from-prefix")
+		}
+		opt.Rewriter, err = redisutil.NewRegexpRewriter(*regex, *repl)
+		if err != nil {
+			log.Fatalf("*** --regex: %v", err)
+		}
+	} else if *fromPrefix != "" {
+		opt.Rewriter, err = redisutil.NewPrefixRewriter(*fromPrefix, *toPrefix)
+		if err != nil {
+			log.Fatalf("*** --from-prefix: %v", err)
+		}
+	}
+
+	if sameInstance && src.DB == dst.DB && opt.Rewriter == nil && len(files) == 0 {
+		log.Fatalf("*** Source and destination are the same. Specify --dst-db, --dst-node or a rewrite rule")
+	}
+
+	if len(files) == 0 && *match == "" && opt.Rewriter != nil {
+		*match = opt.Rewriter.ScanPattern()
+	}
+
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	ctx := context.Background()
+	var lineCount int64
+	if len(files) > 0 {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	} else {
+		go func() {
+			defer close(chLine)
+			client := src.NewClient()
+			defer client.Close()
+
+			var err error
+			lineCount, _, err = redisutil.SampleScanKeys(ctx, client,
+				redisutil.ScanArgs{Match: *match, Count: *scanCount}, 1, 0, chLine)
+			if err != nil {
+				log.Fatalf("*** Scan: %v", err)
+			}
+		}()
+	}
+
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- copyKeys(ctx, index, chLine, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+// copier 1つのworkerでのコピー元・コピー先の接続
+type copier struct {
+	src redis.UniversalClient
+	dst redis.UniversalClient
+	opt copyOption
+	// COPYが使えるか。同じインスタンスでも、Redis 6.2より前ならDUMP/RESTOREにする
+	useCopy bool
+}
+
+func newCopier(opt copyOption) *copier {
+	c := &copier{
+		src:     opt.Src.NewClient(),
+		opt:     opt,
+		useCopy: opt.SameInstance,
+	}
+	if opt.SameInstance && opt.Src.DB == opt.Dst.DB {
+		c.dst = c.src
+	} else {
+		c.dst = opt.Dst.NewClient()
+	}
+	return c
+}
+
+func (c *copier) Close() {
+	if c.dst != c.src {
+		c.dst.Close()
+	}
+	c.src.Close()
+}
+
+// copyKey コピーしたらtrue、コピー先が既にあればfalse、コピー元がなければredis.Nilを返す
+func (c *copier) copyKey(ctx context.Context, src string, dst string) (bool, error) {
+	_, isCluster := c.src.(*redis.ClusterClient)
+	if c.useCopy && !(isCluster && redisutil.KeySlot(src) != redisutil.KeySlot(dst)) {
+		// COPYはgo-redis v8にないのでDoで送る
+		args := []interface{}{"copy", src, dst}
+		if c.opt.Dst.DB != c.opt.Src.DB {
+			args = append(args, "db", c.opt.Dst.DB)
+		}
+		if c.opt.Replace {
+			args = append(args, "replace")
+		}
+		n, err := c.src.Do(ctx, args...).Int64()
+		if err == nil {
+			if n == 1 {
+				return true, nil
+			}
+			// COPYはコピー元がないときもコピー先があるときも0を返すので区別する
+			exists, err := c.src.Exists(ctx, src).Result()
+			if err != nil {
+				return false, err
+			}
+			if exists == 0 {
+				return false, redis.Nil
+			}
+			return false, nil
+		}
+		if !strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+			return false, err
+		}
+		// 以降はDUMP/RESTOREにする
+		c.useCopy = false
+	}
+
+	expireAtMsec, dump, err := redisutil.DumpKey(ctx, c.src, src)
+	if err != nil {
+		return false, err
+	}
+	err = redisutil.RestoreKey(ctx, c.dst, dst, expireAtMsec, dump, c.opt.Replace)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "BUSYKEY") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func copyKeys(ctx context.Context, i uint, chLine <-chan string, opt copyOption) redisutil.Result {
+	c := newCopier(opt)
+	defer c.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]copy: %d\n", i, lc)
+		}
+
+		// {src}    {dst}
+		// {src}のみなら書き換え規則でdstを決める
+		token := strings.SplitN(line, "\t", 2)
+		src := token[0]
+		dst := src
+		if len(token) == 2 {
+			dst = token[1]
+		} else if opt.Rewriter != nil {
+			var ok bool
+			dst, ok = opt.Rewriter.Rewrite(src)
+			if !ok {
+				result.AddCount("not matched", 1)
+				continue
+			}
+		}
+
+		if src == dst && opt.SameInstance && opt.Src.DB == opt.Dst.DB {
+			result.AddCount("same key", 1)
+			continue
+		}
+
+		copied, err := c.copyKey(ctx, src, dst)
+		if err == redis.Nil {
+			result.AddCount("not found", 1)
+			continue
+		} else if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+		if copied {
+			result.AddCount("copied", 1)
+		} else {
+			result.AddCount("destination exists", 1)
+		}
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]copy: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
@@ -3,16 +3,25 @@ package main
 /*
  * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
  * 改行コードは「必ずLF」であることを前提としている。
+ *
+ * --require-typeの確認と削除は、スクリプトで1キーずつ不可分に行う。
+ * --backupでは、バックアップを書いてSyncした後、DUMPがバックアップしたものと同じ場合だけ消す。
+ * 書いている間に値が変わったキーは消さずに数える(期限だけの変更は比べない)。
  */
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	redisutil "github.com/tckz/redis-util"
 )
 
@@ -23,9 +32,17 @@ func main() {
 	showVersion := flag.Bool("version", false, "Show version")
 	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
 	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	unlink := flag.Bool("unlink", false, "Use UNLINK to delete keys without blocking the server")
+	requireType := flag.String("require-type", "", "Comma separated types of keys to be deleted(ex. string,hash). Other keys are skipped")
+	requireMatch := flag.String("require-match", "", "Glob-style pattern of keys to be deleted. Other keys are skipped")
+	backup := flag.String("backup", "", "path/to/prefix-of-file- to write DUMP of keys before deleting. One file per worker. They can be restored by restore command")
+	backupBatch := flag.Int("backup-batch", 100, "Number of keys to back up and sync to the file before deleting them")
+	compress := flag.String("compress", "none", "{gzip|none=without compression}")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -37,8 +54,12 @@ func main() {
 		log.Fatalf("*** Files to load must be specified")
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -49,10 +70,39 @@ func main() {
 		log.Fatalf("*** --worker must be >= 1")
 	}
 
+	if *backupBatch <= 0 {
+		log.Fatalf("*** --backup-batch must be >= 1")
+	}
+
+	opt := delOption{
+		Unlink:       *unlink,
+		Match:        *requireMatch,
+		BackupBatch:  *backupBatch,
+		BackupFailed: new(int32),
+	}
+	if *requireType != "" {
+		opt.Types = map[string]bool{}
+		for _, t := range strings.Split(*requireType, ",") {
+			opt.Types[strings.ToLower(strings.TrimSpace(t))] = true
+		}
+	}
+
 	chLine := make(chan string, *worker)
 	chFile := make(chan uint64)
 	from := time.Now()
 
+	// 消してから書けないことがないよう、workerごとのファイルに同期的に書く
+	backupWriters := make([]*redisutil.SyncWriter, *worker)
+	if *backup != "" {
+		for i := range backupWriters {
+			w, err := redisutil.CreateSyncWriter(fmt.Sprintf("%s%03d", *backup, i), *compress)
+			if err != nil {
+				log.Fatalf("*** --backup: %v", err)
+			}
+			backupWriters[i] = w
+		}
+	}
+
 	var lineCount int64
 	for i, file := range files {
 		// ファイルを分割並列入力して、入力行をチャンネルに投げる
@@ -79,7 +129,7 @@ func main() {
 	for i := uint(0); i < *worker; i++ {
 		index := i
 		go func() {
-			chResult <- del(ctx, index, nodes, chLine)
+			chResult <- del(ctx, index, conn, chLine, backupWriters[index], opt)
 		}()
 	}
 
@@ -90,19 +140,175 @@ func main() {
 		totalResult = totalResult.Combine(result)
 	}
 
+	for _, w := range backupWriters {
+		if w == nil {
+			continue
+		}
+		// 消したキーの分は書いてSync済みなので、ここで失敗しても失われない
+		if err := w.Close(); err != nil {
+			totalResult.AddError(err.Error())
+		}
+	}
+
 	elapsed := time.Since(from)
-	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
-		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+type delOption struct {
+	Unlink bool
+	// nilなら型を問わない
+	Types map[string]bool
+	// 空ならキー名を問わない
+	Match       string
+	BackupBatch int
+	// バックアップに失敗したら全workerで削除をやめる
+	BackupFailed *int32
+}
+
+// 型とDUMPを確かめてから消す。確認と削除の間にキーが変わらないよう、スクリプトで不可分に行う
+// KEYS[1]: キー, ARGV[1]: del|unlink, ARGV[2]: DUMPのsha1(空なら比べない), ARGV[3...]: 消してよい型(なければ問わない)
+// 消した件数、キーがなければ-2、型が違えば-3、DUMPが違えば-4を返す
+const checkAndDeleteScript = `
+local t = redis.call('TYPE', KEYS[1])['ok']
+if t == 'none' then
+  return -2
+end
+if #ARGV > 2 then
+  local allowed = false
+  for i = 3, #ARGV do
+    if ARGV[i] == t then
+      allowed = true
+    end
+  end
+  if not allowed then
+    return -3
+  end
+end
+if ARGV[2] ~= '' and redis.sha1hex(redis.call('DUMP', KEYS[1])) ~= ARGV[2] then
+  return -4
+end
+return redis.call(ARGV[1], KEYS[1])
+`
+
+var checkAndDelete = redis.NewScript(checkAndDeleteScript)
+
+// pendingKey バックアップを書いてSyncするのを待っているキー
+type pendingKey struct {
+	key string
+	// DUMPのsha1
+	digest string
 }
 
-func del(ctx context.Context, i uint, nodes []string, chLine <-chan string) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+// deleter バックアップを書いてから消すまでを、BackupBatch件ずつまとめて行う
+type deleter struct {
+	client redis.UniversalClient
+	// nilならバックアップしない
+	backup  *redisutil.SyncWriter
+	opt     delOption
+	result  *redisutil.Result
+	pending []pendingKey
+	lines   []string
+}
+
+func (d *deleter) add(ctx context.Context, key string) {
+	if d.backup == nil {
+		d.delete(ctx, key, "")
+		return
+	}
+
+	if atomic.LoadInt32(d.opt.BackupFailed) != 0 {
+		d.result.AddCount("not deleted by backup error", 1)
+		return
+	}
+
+	// 消す前に書き出しておき、restoreで戻せるようにする
+	expireAtMsec, dump, err := redisutil.DumpKey(ctx, d.client, key)
+	if err == redis.Nil {
+		d.result.AddCount("not found", 1)
+		return
+	} else if err != nil {
+		d.result.AddError(err.Error())
+		return
+	}
+	digest := sha1.Sum([]byte(dump))
+	d.pending = append(d.pending, pendingKey{key: key, digest: hex.EncodeToString(digest[:])})
+	d.lines = append(d.lines, redisutil.FormatDumpLine(key, expireAtMsec, dump))
+	if len(d.pending) >= d.opt.BackupBatch {
+		d.flush(ctx)
+	}
+}
+
+// flush 溜めたバックアップを書いてSyncできたら、そのキーを消す
+func (d *deleter) flush(ctx context.Context) {
+	if len(d.pending) == 0 {
+		return
+	}
+	defer func() {
+		d.pending = d.pending[:0]
+		d.lines = d.lines[:0]
+	}()
+
+	if atomic.LoadInt32(d.opt.BackupFailed) != 0 {
+		d.result.AddCount("not deleted by backup error", uint64(len(d.pending)))
+		return
+	}
+	if err := d.backup.WriteLines(d.lines); err != nil {
+		atomic.StoreInt32(d.opt.BackupFailed, 1)
+		d.result.AddError("Backup: " + err.Error())
+		d.result.AddCount("not deleted by backup error", uint64(len(d.pending)))
+		return
+	}
+	d.result.AddCount("backed up", uint64(len(d.pending)))
+
+	for _, p := range d.pending {
+		d.delete(ctx, p.key, p.digest)
+	}
+}
+
+// delete キーを消す。digestが空でなければ、DUMPのsha1が同じ場合だけ消す
+func (d *deleter) delete(ctx context.Context, key string, digest string) {
+	cmd := "del"
+	if d.opt.Unlink {
+		cmd = "unlink"
+	}
+
+	var n int64
+	var err error
+	if d.opt.Types == nil && digest == "" {
+		n, err = d.client.Do(ctx, cmd, key).Int64()
+	} else {
+		args := []interface{}{cmd, digest}
+		for t := range d.opt.Types {
+			args = append(args, t)
+		}
+		n, err = checkAndDelete.Run(ctx, d.client, []string{key}, args...).Int64()
+	}
+	if err != nil {
+		d.result.AddError(err.Error())
+		return
+	}
+	switch n {
+	case 1:
+		d.result.AddCount("deleted", 1)
+	case -3:
+		d.result.AddCount("skipped by type", 1)
+	case -4:
+		d.result.AddCount("not deleted by change after backup", 1)
+	default:
+		d.result.AddCount("not found", 1)
+	}
+}
+
+func del(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, backup *redisutil.SyncWriter, opt delOption) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
 	from := time.Now()
 
 	result := redisutil.NewResult()
+	d := &deleter{client: client, backup: backup, opt: opt, result: &result}
 
 	for line := range chLine {
 		lc++
@@ -110,12 +316,31 @@ func del(ctx context.Context, i uint, nodes []string, chLine <-chan string) redi
 			fmt.Fprintf(os.Stderr, "[%02d]del: %d\n", i, lc)
 		}
 
-		_, err := client.Del(ctx, line).Result()
-		if err != nil {
-			result.AddError(err.Error())
+		if opt.Match != "" && !redisutil.MatchPattern(opt.Match, line) {
+			result.AddCount("skipped by match", 1)
 			continue
 		}
+
+		// バックアップに余計なキーを書かないよう先に確かめる。消すときにも確かめ直す
+		if opt.Types != nil && backup != nil {
+			t, err := client.Type(ctx, line).Result()
+			if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+			if t == "none" {
+				result.AddCount("not found", 1)
+				continue
+			}
+			if !opt.Types[t] {
+				result.AddCount("skipped by type", 1)
+				continue
+			}
+		}
+
+		d.add(ctx, line)
 	}
+	d.flush(ctx)
 
 	elapsed := time.Since(from)
 	fmt.Fprintf(os.Stderr, "[%02d]del: %d, Elapsed: %s\n", i, lc, elapsed)
@@ -0,0 +1,226 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	scriptFile := flag.String("script", "", "path/to/lua-script")
+	numKeys := flag.Int("numkeys", 1, "Number of leading columns passed as KEYS. The rest are passed as ARGV")
+	reply := flag.Bool("reply", false, "Write script results to output files")
+	withoutKey := flag.Bool("without-key", false, "Whether output with the 1st column or not")
+	nilMarker := flag.String("nil-marker", "(nil)", "Reply written for nil replies with --reply, so that output lines correspond to input lines")
+	out := flag.String("out", "out-", "path/to/prefix-of-file- with --reply")
+	outSplit := flag.Uint("out-split", 5, "Number of output files with --reply")
+	compress := flag.String("compress", "none", "{gzip|none=without compression} with --reply")
+	worker := flag.Uint("worker", 32, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	if *scriptFile == "" {
+		log.Fatalf("*** --script must be specified")
+	}
+
+	if *numKeys < 0 {
+		log.Fatalf("*** --numkeys must be >= 0")
+	}
+
+	src, err := os.ReadFile(*scriptFile)
+	if err != nil {
+		log.Fatalf("*** ReadFile: %v", err)
+	}
+	script := redis.NewScript(string(src))
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *reply && *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	chLine := make(chan string, *worker)
+	chFile := make(chan uint64)
+	from := time.Now()
+
+	var chOut chan string
+	var wgOut *sync.WaitGroup
+	if *reply {
+		chOut = make(chan string, *outSplit)
+		wgOut = redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+	}
+
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	var lineCount int64
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	opt := evalOption{
+		NumKeys:    *numKeys,
+		WithoutKey: *withoutKey,
+		NilMarker:  *nilMarker,
+	}
+
+	ctx := context.Background()
+	// 全ノードにSCRIPT LOADしておく。以降に追加・再起動されたノードはEVALSHAのNOSCRIPTでEVALに切り替わる
+	func() {
+		client := conn.NewClient()
+		defer client.Close()
+		sha, err := script.Load(ctx, client).Result()
+		if err != nil {
+			log.Fatalf("*** SCRIPT LOAD: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Script loaded: %s\n", sha)
+	}()
+
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- eval(ctx, index, conn, script, chLine, chOut, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	if chOut != nil {
+		close(chOut)
+		wgOut.Wait()
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+type evalOption struct {
+	NumKeys    int
+	WithoutKey bool
+	// nilの応答の代わりに出力する文字列
+	NilMarker string
+}
+
+// eval 入力行の先頭NumKeys列をKEYS、残りをARGVとしてスクリプトを実行する。chOutがnilなら結果は捨てる
+func eval(ctx context.Context, i uint, conn redisutil.Connection, script *redis.Script, chLine <-chan string, chOut chan<- string, opt evalOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]eval: %d\n", i, lc)
+		}
+
+		columns := strings.Split(line, "\t")
+		if len(columns) < opt.NumKeys {
+			result.AddError(fmt.Sprintf("Number of tokens = %d < %d", len(columns), opt.NumKeys))
+			continue
+		}
+		argv := make([]interface{}, 0, len(columns)-opt.NumKeys)
+		for _, c := range columns[opt.NumKeys:] {
+			argv = append(argv, c)
+		}
+
+		// EVALSHAで実行し、NOSCRIPTならEVALで再実行される
+		var s string
+		v, err := script.Run(ctx, client, columns[:opt.NumKeys], argv...).Result()
+		if err == redis.Nil {
+			// 入力行と出力行が対応するよう、nilでも行を出す
+			result.AddCount("nil replies", 1)
+			s = opt.NilMarker
+		} else if err != nil {
+			result.AddError(err.Error())
+			continue
+		} else {
+			s = redisutil.FormatReply(v)
+		}
+
+		if chOut == nil {
+			continue
+		}
+
+		if opt.WithoutKey {
+			chOut <- s
+		} else {
+			chOut <- columns[0] + "\t" + s
+		}
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]eval: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
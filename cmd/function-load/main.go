@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var (
+	version string
+)
+
+func main() {
+	optVersion := flag.Bool("version", false, "Show version")
+	optReplace := flag.Bool("replace", false, "Replace the library if it already exists(FUNCTION LOAD REPLACE)")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *optVersion {
+		fmt.Printf("%s\n", version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Library files to load must be specified")
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	cl := conn.NewClient()
+	defer cl.Close()
+
+	from := time.Now()
+	ctx := context.Background()
+	for _, file := range files {
+		code, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("*** ReadFile: %v", err)
+		}
+
+		args := []interface{}{"function", "load"}
+		if *optReplace {
+			args = append(args, "replace")
+		}
+		args = append(args, string(code))
+
+		// FUNCTION LOADはキーを持たないので、クラスタでは全マスタに送る。レプリカへは複製される
+		err = redisutil.ForEachMasterNode(ctx, cl, func(ctx context.Context, node redis.UniversalClient) error {
+			name, err := node.Do(ctx, args...).Text()
+			if err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+			log.Printf("Loaded: %s, library=%s", file, name)
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("*** FUNCTION LOAD: %v", err)
+		}
+	}
+	log.Printf("Elapsed: %s\n", time.Since(from))
+}
@@ -27,9 +27,12 @@ func main() {
 	compress := flag.String("compress", "none", "{gzip|none=without compression}")
 	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
 	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	conn.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -41,8 +44,12 @@ func main() {
 		log.Fatalf("*** Files to load must be specified")
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -90,7 +97,7 @@ func main() {
 	for i := uint(0); i < *worker; i++ {
 		index := i
 		go func() {
-			chResult <- get(ctx, index, nodes, chLine, chOut, *withoutKey)
+			chResult <- get(ctx, index, conn, chLine, chOut, *withoutKey)
 		}()
 	}
 
@@ -109,8 +116,8 @@ func main() {
 		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
 }
 
-func get(ctx context.Context, i uint, nodes []string, chLine <-chan string, chOut chan<- string, withoutKey bool) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+func get(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, withoutKey bool) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
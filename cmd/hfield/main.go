@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	worker := flag.Uint("worker", 32, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	op := flag.String("op", "", "Operation for fields {hdel|hincrby|hincrbyfloat|hsetnx}")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	f, ok := fieldOps[*op]
+	if !ok {
+		log.Fatalf("*** Unknown --op: %s", *op)
+	}
+
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	chFile := make(chan uint64)
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	var lineCount int64
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- hfield(ctx, index, conn, chLine, *op, f)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors, totalResult.Counts)
+}
+
+type fieldOp func(ctx context.Context, client redis.UniversalClient, line string, result *redisutil.Result) error
+
+var fieldOps = map[string]fieldOp{
+	"hdel":         hdel,
+	"hincrby":      hincrby,
+	"hincrbyfloat": hincrbyfloat,
+	"hsetnx":       hsetnx,
+}
+
+// hdel {key}	{field}...
+func hdel(ctx context.Context, client redis.UniversalClient, line string, result *redisutil.Result) error {
+	tokens := strings.SplitN(line, "\t", -1)
+	if len(tokens) < 2 {
+		return fmt.Errorf("Number of tokens = %d", len(tokens))
+	}
+
+	n, err := client.HDel(ctx, tokens[0], tokens[1:]...).Result()
+	if err != nil {
+		return err
+	}
+	result.AddCount("deleted", uint64(n))
+	return nil
+}
+
+// hincrby {key}	{field}	{delta}
+func hincrby(ctx context.Context, client redis.UniversalClient, line string, result *redisutil.Result) error {
+	tokens := strings.SplitN(line, "\t", -1)
+	if len(tokens) != 3 {
+		return fmt.Errorf("Number of tokens != 3")
+	}
+
+	delta, err := strconv.ParseInt(tokens[2], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.HIncrBy(ctx, tokens[0], tokens[1], delta).Result()
+	if err != nil {
+		return err
+	}
+	result.AddCount("incremented", 1)
+	return nil
+}
+
+// hincrbyfloat {key}	{field}	{delta}
+func hincrbyfloat(ctx context.Context, client redis.UniversalClient, line string, result *redisutil.Result) error {
+	tokens := strings.SplitN(line, "\t", -1)
+	if len(tokens) != 3 {
+		return fmt.Errorf("Number of tokens != 3")
+	}
+
+	delta, err := strconv.ParseFloat(tokens[2], 64)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.HIncrByFloat(ctx, tokens[0], tokens[1], delta).Result()
+	if err != nil {
+		return err
+	}
+	result.AddCount("incremented", 1)
+	return nil
+}
+
+// hsetnx {key}	{field}	{value}
+func hsetnx(ctx context.Context, client redis.UniversalClient, line string, result *redisutil.Result) error {
+	tokens := strings.SplitN(line, "\t", 3)
+	if len(tokens) != 3 {
+		return fmt.Errorf("Number of tokens != 3")
+	}
+
+	set, err := client.HSetNX(ctx, tokens[0], tokens[1], tokens[2]).Result()
+	if err != nil {
+		return err
+	}
+	if set {
+		result.AddCount("set", 1)
+	} else {
+		result.AddCount("exists", 1)
+	}
+	return nil
+}
+
+func hfield(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, op string, f fieldOp) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]%s: %d\n", i, op, lc)
+		}
+
+		if err := f(ctx, client, line, &result); err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "[%02d]%s: %d, Elapsed: %s\n", i, op, lc, time.Since(from))
+
+	result.Lines = lc
+
+	return result
+}
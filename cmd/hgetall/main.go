@@ -12,8 +12,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	redisutil "github.com/tckz/redis-util"
 )
 
@@ -28,9 +30,17 @@ func main() {
 	compress := flag.String("compress", "none", "{gzip|none=without compression}")
 	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
 	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	fields := flag.String("fields", "", "Comma separated fields to output by HMGET(ex. a,b,c)")
+	match := flag.String("match", "", "Pattern of fields to output by HSCAN MATCH")
+	stream := flag.String("stream", "none", "Fetch by HSCAN and output incrementally {none|field=one line per field|chunk=JSON per --chunk fields}")
+	chunk := flag.Int("chunk", 1000, "Max number of fields per JSON with --stream=chunk")
+	scanCount := flag.Int64("scan-count", 1000, "HSCAN count at once")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	conn.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -42,8 +52,12 @@ func main() {
 		log.Fatalf("*** Files to load must be specified")
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -58,6 +72,36 @@ func main() {
 		log.Fatalf("*** --worker must be >= 1")
 	}
 
+	opt := hgetallOption{
+		WithoutKey: *withoutKey,
+		Match:      *match,
+		Stream:     *stream,
+		Chunk:      *chunk,
+		ScanCount:  *scanCount,
+	}
+	if *fields != "" {
+		opt.Fields = strings.Split(*fields, ",")
+	}
+
+	switch *stream {
+	case "none":
+		if *match != "" {
+			// HSCANで集めて1つのJSONにする
+			opt.Stream = "chunk"
+			opt.Chunk = 0
+		}
+	case "field", "chunk":
+		if *stream == "chunk" && *chunk <= 0 {
+			log.Fatalf("*** --chunk must be >= 1")
+		}
+	default:
+		log.Fatalf("*** Unknown --stream: %s", *stream)
+	}
+
+	if len(opt.Fields) > 0 && opt.Stream != "none" {
+		log.Fatalf("*** --fields can not be used with --match or --stream")
+	}
+
 	chOut := make(chan string, *outSplit)
 	chLine := make(chan string, *worker)
 	chFile := make(chan uint64)
@@ -92,7 +136,7 @@ func main() {
 		// 入力行を受け取ってredisからgetする
 		index := i
 		go func() {
-			chResult <- hgetall(ctx, index, nodes, chLine, chOut, *withoutKey)
+			chResult <- hgetall(ctx, index, conn, chLine, chOut, opt)
 		}()
 	}
 
@@ -107,12 +151,53 @@ func main() {
 	wgOut.Wait()
 
 	elapsed := time.Since(from)
-	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
-		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+type hgetallOption struct {
+	WithoutKey bool
+	Fields     []string
+	Match      string
+	// none, field, chunk
+	Stream    string
+	Chunk     int
+	ScanCount int64
+}
+
+func (o hgetallOption) line(key string, s string) string {
+	if o.WithoutKey {
+		return s
+	}
+	return key + "\t" + s
+}
+
+// notFound 何も取れなかったとき、--fieldsや--matchに合うフィールドがなかったのか、キーがないのかを区別して数える
+func notFound(ctx context.Context, client redis.UniversalClient, key string, opt hgetallOption, result *redisutil.Result) {
+	if len(opt.Fields) == 0 && opt.Match == "" {
+		result.AddError("Key does not exist")
+		return
+	}
+	n, err := client.Exists(ctx, key).Result()
+	if err != nil {
+		result.AddError(err.Error())
+	} else if n == 0 {
+		result.AddError("Key does not exist")
+	} else {
+		result.AddCount("no matching fields", 1)
+	}
+}
+
+func marshal(rec map[string]string) string {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
 }
 
-func hgetall(ctx context.Context, i uint, nodes []string, chLine <-chan string, chOut chan<- string, withoutKey bool) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+func hgetall(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, opt hgetallOption) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
@@ -126,26 +211,38 @@ func hgetall(ctx context.Context, i uint, nodes []string, chLine <-chan string,
 			fmt.Fprintf(os.Stderr, "[%02d]hgetall: %d\n", i, lc)
 		}
 
-		rec, err := client.HGetAll(ctx, key).Result()
-		if err != nil {
-			result.AddError(err.Error())
+		if opt.Stream != "none" {
+			hscan(ctx, client, key, chOut, opt, &result)
 			continue
 		}
 
-		if len(rec) == 0 {
-			result.AddError("Key does not exist")
+		var rec map[string]string
+		if len(opt.Fields) > 0 {
+			values, err := client.HMGet(ctx, key, opt.Fields...).Result()
+			if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+			rec = make(map[string]string, len(values))
+			for j, v := range values {
+				// 存在しないフィールドはnil
+				if s, ok := v.(string); ok {
+					rec[opt.Fields[j]] = s
+				}
+			}
 		} else {
-			b, err := json.Marshal(rec)
+			var err error
+			rec, err = client.HGetAll(ctx, key).Result()
 			if err != nil {
-				panic(err)
+				result.AddError(err.Error())
+				continue
 			}
+		}
 
-			s := string(b)
-			if withoutKey {
-				chOut <- s
-			} else {
-				chOut <- key + "\t" + s
-			}
+		if len(rec) == 0 {
+			notFound(ctx, client, key, opt, &result)
+		} else {
+			chOut <- opt.line(key, marshal(rec))
 		}
 	}
 
@@ -156,3 +253,43 @@ func hgetall(ctx context.Context, i uint, nodes []string, chLine <-chan string,
 
 	return result
 }
+
+// hscan 巨大なハッシュでサーバを止めないようにHSCANで少しずつ取り出して出力する
+// fieldなら1フィールド1行、chunkならChunkフィールドずつのJSON(Chunkが0なら全体で1つ)にする
+// HSCANの性質上、同じフィールドが重複して出力されることがある
+func hscan(ctx context.Context, client redis.UniversalClient, key string, chOut chan<- string, opt hgetallOption, result *redisutil.Result) {
+	rec := map[string]string{}
+	found := false
+	var cursor uint64
+	for {
+		var kv []string
+		var err error
+		kv, cursor, err = client.HScan(ctx, key, cursor, opt.Match, opt.ScanCount).Result()
+		if err != nil {
+			result.AddError(err.Error())
+			return
+		}
+		for j := 0; j+1 < len(kv); j += 2 {
+			found = true
+			if opt.Stream == "field" {
+				chOut <- opt.line(key, kv[j]+"\t"+kv[j+1])
+				continue
+			}
+			rec[kv[j]] = kv[j+1]
+			if opt.Chunk > 0 && len(rec) >= opt.Chunk {
+				chOut <- opt.line(key, marshal(rec))
+				rec = map[string]string{}
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if len(rec) > 0 {
+		chOut <- opt.line(key, marshal(rec))
+	}
+	if !found {
+		notFound(ctx, client, key, opt, result)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	redisutil "github.com/tckz/redis-util"
 )
 
@@ -25,9 +26,14 @@ func main() {
 	showVersion := flag.Bool("version", false, "Show version")
 	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
 	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	replace := flag.Bool("replace", false, "Replace whole hash atomically(DEL and HSET in MULTI)")
+	lineTTL := flag.String("line-ttl", "none", "Read TTL of the key from the 2nd column of each line as {key}\\t{ttl}\\t{json} {none|ttl|expireat}")
+	fieldTTLKey := flag.String("field-ttl-key", "", "Name of JSON member holding {field: ttl} to set per-field expiry by HPEXPIRE(Redis 7.4+)")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -39,8 +45,12 @@ func main() {
 		log.Fatalf("*** Files to load must be specified")
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -51,6 +61,18 @@ func main() {
 		log.Fatalf("*** --worker must be >= 1")
 	}
 
+	switch *lineTTL {
+	case "none", "ttl", "expireat":
+	default:
+		log.Fatalf("*** Unknown --line-ttl: %s", *lineTTL)
+	}
+
+	opt := hsetOption{
+		Replace:     *replace,
+		LineTTL:     *lineTTL,
+		FieldTTLKey: *fieldTTLKey,
+	}
+
 	chLine := make(chan string, *worker)
 	chFile := make(chan uint64)
 	from := time.Now()
@@ -81,7 +103,7 @@ func main() {
 	for i := uint(0); i < *worker; i++ {
 		index := i
 		go func() {
-			chResult <- hset(ctx, index, nodes, chLine)
+			chResult <- hset(ctx, index, conn, chLine, opt)
 		}()
 	}
 
@@ -97,8 +119,15 @@ func main() {
 		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
 }
 
-func hset(ctx context.Context, i uint, nodes []string, chLine <-chan string) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+type hsetOption struct {
+	Replace bool
+	// none, ttl, expireat
+	LineTTL     string
+	FieldTTLKey string
+}
+
+func hset(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, opt hsetOption) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
@@ -112,21 +141,109 @@ func hset(ctx context.Context, i uint, nodes []string, chLine <-chan string) red
 			fmt.Fprintf(os.Stderr, "[%02d]hset: %d\n", i, lc)
 		}
 
-		// {key}    {json}
-		token := strings.SplitN(line, "\t", 2)
-		if len(token) != 2 {
-			result.AddError("Number of tokens != 2")
-			continue
+		var key, js string
+		var ttl time.Duration
+		var expireAt time.Time
+		if opt.LineTTL == "none" {
+			// {key}    {json}
+			token := strings.SplitN(line, "\t", 2)
+			if len(token) != 2 {
+				result.AddError("Number of tokens != 2")
+				continue
+			}
+			key, js = token[0], token[1]
+		} else {
+			// {key}    {ttl}	{json}
+			token := strings.SplitN(line, "\t", 3)
+			if len(token) != 3 {
+				result.AddError("Number of tokens != 3")
+				continue
+			}
+			var err error
+			ttl, expireAt, err = redisutil.ParseLineTTL(opt.LineTTL, token[1])
+			if err == redisutil.ErrLineTTLNotExist {
+				result.AddCount("missing", 1)
+				continue
+			} else if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+			key, js = token[0], token[2]
 		}
 
 		var m map[string]interface{}
-		err := json.Unmarshal([]byte(token[1]), &m)
-		if err != nil {
+		if err := json.Unmarshal([]byte(js), &m); err != nil {
 			result.AddError("Invalid json")
 			continue
 		}
 
-		_, err = client.HSet(ctx, token[0], m).Result()
+		var fieldTTLs map[string]interface{}
+		if opt.FieldTTLKey != "" {
+			if v, ok := m[opt.FieldTTLKey]; ok {
+				delete(m, opt.FieldTTLKey)
+				fieldTTLs, ok = v.(map[string]interface{})
+				if !ok {
+					result.AddError("Invalid field ttl")
+					continue
+				}
+			}
+		}
+
+		values := make([]interface{}, 0, len(m)*2)
+		var err error
+		for f, v := range m {
+			var s string
+			s, err = redisutil.StringifyJSONValueAsArg(v)
+			if err != nil {
+				break
+			}
+			values = append(values, f, s)
+		}
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+
+		fieldExpires := make(map[string]time.Duration, len(fieldTTLs))
+		for f, v := range fieldTTLs {
+			var d time.Duration
+			d, err = redisutil.ParseFieldTTL(v)
+			if err != nil {
+				break
+			}
+			fieldExpires[f] = d
+		}
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+
+		if !opt.Replace && ttl <= 0 && expireAt.IsZero() && len(fieldExpires) == 0 {
+			_, err = client.HSet(ctx, key, values...).Result()
+			if err != nil {
+				result.AddError(err.Error())
+			}
+			continue
+		}
+
+		// 置換やTTLの設定を途中の状態が見えないようにMULTIでまとめる
+		_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if opt.Replace {
+				pipe.Del(ctx, key)
+			}
+			if len(values) > 0 {
+				pipe.HSet(ctx, key, values...)
+			}
+			if ttl > 0 {
+				pipe.PExpire(ctx, key, ttl)
+			} else if !expireAt.IsZero() {
+				pipe.PExpireAt(ctx, key, expireAt)
+			}
+			for f, d := range fieldExpires {
+				pipe.Do(ctx, "hpexpire", key, int64(d/time.Millisecond), "fields", 1, f)
+			}
+			return nil
+		})
 		if err != nil {
 			result.AddError(err.Error())
 			continue
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	worker := flag.Uint("worker", 32, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	randomKeys := flag.Uint("random", 0, "Number of members to generate")
+	randomPrefix := flag.String("random-prefix", "rand-", "Prefix of random generated member")
+	key := flag.String("key", "", "Key of LIST")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if *randomKeys > 0 {
+		if *key == "" {
+			log.Fatalf("*** key must be specified")
+		}
+	} else {
+		if len(files) == 0 {
+			log.Fatalf("*** Files to load must be specified")
+		}
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	var lineCount int64
+	if *randomKeys > 0 {
+		go func() {
+			for i := uint(0); i < *randomKeys; i++ {
+				member := uuid.Must(uuid.NewRandom()).String()
+				chLine <- fmt.Sprintf("%s\t%s%s", *key, *randomPrefix, member)
+			}
+			close(chLine)
+		}()
+	} else {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	}
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- lpush(ctx, index, conn, chLine)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+}
+
+func lpush(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	members := make([]interface{}, 0, 1024)
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]lpush: %d\n", i, lc)
+		}
+
+		// {key}    {member}...
+		tokens := strings.SplitN(line, "\t", -1)
+		tokenCount := len(tokens)
+		if tokenCount < 2 {
+			result.AddError(fmt.Sprintf("Number of tokens = %d", tokenCount))
+			continue
+		}
+
+		members = members[:0]
+		for _, e := range tokens[1:] {
+			members = append(members, e)
+		}
+		_, err := client.LPush(ctx, tokens[0], members...).Result()
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "[%02d]lpush: %d, Elapsed: %s\n", i, lc, time.Since(from))
+
+	result.Lines = lc
+
+	return result
+}
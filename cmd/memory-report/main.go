@@ -0,0 +1,401 @@
+package main
+
+/*
+ * キーのプレフィックスごとに、MEMORY USAGEによるメモリ使用量を集計する。
+ * キーは入力ファイル、SCAN(全マスタ)、RANDOMKEYのいずれかから取る。
+ * サンプリングした場合の推定値は、SCANを最後まで行えば--scan-rateから、
+ * RANDOMKEYか--samplesでSCANを打ち切った場合は全マスタのDBSIZEから求める。
+ * --matchを指定してSCANを打ち切った場合は対象のキーの総数がわからないので推定値を出さない。
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+type prefixStat struct {
+	Count  uint64
+	Total  uint64
+	Max    uint64
+	MaxKey string
+}
+
+type report map[string]*prefixStat
+
+func (r report) stat(prefix string) *prefixStat {
+	st, ok := r[prefix]
+	if !ok {
+		st = &prefixStat{}
+		r[prefix] = st
+	}
+	return st
+}
+
+func (st *prefixStat) add(key string, bytes uint64) {
+	st.Count++
+	st.Total += bytes
+	if bytes > st.Max {
+		st.Max = bytes
+		st.MaxKey = key
+	}
+}
+
+func (st *prefixStat) merge(o *prefixStat) {
+	st.Count += o.Count
+	st.Total += o.Total
+	if o.Max > st.Max {
+		st.Max = o.Max
+		st.MaxKey = o.MaxKey
+	}
+}
+
+func (st *prefixStat) avg() uint64 {
+	if st.Count == 0 {
+		return 0
+	}
+	return st.Total / st.Count
+}
+
+func (r report) merge(o report) {
+	for prefix, ost := range o {
+		r.stat(prefix).merge(ost)
+	}
+}
+
+type reportOption struct {
+	Delimiter string
+	Depth     int
+	Samples   int
+	Batch     int
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	mode := flag.String("mode", "scan", "How to get keys when no files specified {scan|random}")
+	samples := flag.Uint64("samples", 0, "Number of keys to sample(0=all scanned keys in scan mode)")
+	scanRate := flag.Float64("scan-rate", 1, "Fraction of scanned keys to be sampled in scan mode")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	match := flag.String("match", "", "Pattern of keys to scan in scan mode")
+	delimiter := flag.String("delimiter", ":", "Delimiter of key prefix")
+	depth := flag.Int("depth", 1, "Number of delimited elements to be a prefix")
+	memSamples := flag.Int("memory-samples", 5, "Number of nested values sampled by MEMORY USAGE SAMPLES(0=all)")
+	format := flag.String("format", "text", "{text|json}")
+	batch := flag.Int("batch", 100, "Number of MEMORY USAGE to send in a pipeline")
+	worker := flag.Uint("worker", 8, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if len(files) == 0 {
+		switch *mode {
+		case "random":
+			if *samples == 0 {
+				log.Fatalf("*** --samples must be >= 1 in random mode")
+			}
+		case "scan":
+			if *scanRate <= 0 || *scanRate > 1 {
+				log.Fatalf("*** --scan-rate must be in (0, 1]")
+			}
+		default:
+			log.Fatalf("*** Unknown --mode: %s", *mode)
+		}
+	}
+
+	if *memSamples < 0 {
+		log.Fatalf("*** --memory-samples must be >= 0")
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("*** Unknown --format: %s", *format)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *batch <= 0 {
+		log.Fatalf("*** --batch must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	opt := reportOption{
+		Delimiter: *delimiter,
+		Depth:     *depth,
+		Samples:   *memSamples,
+		Batch:     *batch,
+	}
+
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	ctx := context.Background()
+	var lineCount int64
+	// SCANを--samplesで途中で止めたか
+	var scanTruncated bool
+	if len(files) > 0 {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	} else {
+		go func() {
+			defer close(chLine)
+			client := conn.NewClient()
+			defer client.Close()
+
+			var err error
+			if *mode == "random" {
+				lineCount, err = redisutil.SampleRandomKeys(ctx, client, *samples, chLine)
+			} else {
+				lineCount, scanTruncated, err = redisutil.SampleScanKeys(ctx, client,
+					redisutil.ScanArgs{Match: *match, Count: *scanCount}, *scanRate, *samples, chLine)
+			}
+			if err != nil {
+				log.Fatalf("*** Sampling: %v", err)
+			}
+		}()
+	}
+
+	chResult := make(chan redisutil.Result, *worker)
+	chReport := make(chan report, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- memoryReport(ctx, index, conn, chLine, chReport, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	totalReport := report{}
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+		totalReport.merge(<-chReport)
+	}
+
+	// サンプリングした場合の全体の推定値の倍率。0なら推定できない
+	scale := 1.0
+	if len(files) == 0 {
+		var measured uint64
+		for _, st := range totalReport {
+			measured += st.Count
+		}
+		truncated := *mode == "random" || scanTruncated
+		switch {
+		case !truncated:
+			scale = 1 / *scanRate
+		case *mode == "scan" && *match != "":
+			scale = 0
+			fmt.Fprintf(os.Stderr, "Estimates are not available since scan was stopped by --samples with --match\n")
+		case measured == 0:
+			scale = 0
+		default:
+			client := conn.NewClient()
+			dbSize, err := redisutil.TotalDBSize(ctx, client)
+			client.Close()
+			if err != nil {
+				log.Fatalf("*** DBSIZE: %v", err)
+			}
+			scale = float64(dbSize) / float64(measured)
+		}
+	}
+
+	if *format == "json" {
+		writeJSON(totalReport, scale)
+	} else {
+		writeText(totalReport, scale)
+	}
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+}
+
+// sortedPrefixes 合計バイト数の多い順に並べたプレフィックス
+func sortedPrefixes(r report) []string {
+	prefixes := make([]string, 0, len(r))
+	for prefix := range r {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := r[prefixes[i]], r[prefixes[j]]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	return prefixes
+}
+
+func writeText(r report, scale float64) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "prefix\tkeys\ttotal bytes\tavg bytes\tmax bytes\test. keys\test. total bytes\tmax key\t")
+
+	total := &prefixStat{}
+	row := func(prefix string, st *prefixStat) {
+		estKeys, estTotal := "-", "-"
+		if scale > 0 {
+			estKeys = fmt.Sprintf("%.0f", float64(st.Count)*scale)
+			estTotal = fmt.Sprintf("%.0f", float64(st.Total)*scale)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t\n", prefix, st.Count, st.Total, st.avg(), st.Max,
+			estKeys, estTotal, st.MaxKey)
+	}
+	for _, prefix := range sortedPrefixes(r) {
+		st := r[prefix]
+		row(prefix, st)
+		total.merge(st)
+	}
+	row("(total)", total)
+}
+
+type jsonPrefix struct {
+	Prefix     string `json:"prefix"`
+	Keys       uint64 `json:"keys"`
+	TotalBytes uint64 `json:"total_bytes"`
+	AvgBytes   uint64 `json:"avg_bytes"`
+	MaxBytes   uint64 `json:"max_bytes"`
+	MaxKey     string `json:"max_key"`
+	// 推定できなければ出力しない
+	EstKeys       *float64 `json:"est_keys,omitempty"`
+	EstTotalBytes *float64 `json:"est_total_bytes,omitempty"`
+}
+
+func writeJSON(r report, scale float64) {
+	out := make([]jsonPrefix, 0, len(r))
+	for _, prefix := range sortedPrefixes(r) {
+		st := r[prefix]
+		jp := jsonPrefix{
+			Prefix:     prefix,
+			Keys:       st.Count,
+			TotalBytes: st.Total,
+			AvgBytes:   st.avg(),
+			MaxBytes:   st.Max,
+			MaxKey:     st.MaxKey,
+		}
+		if scale > 0 {
+			estKeys := float64(st.Count) * scale
+			estTotal := float64(st.Total) * scale
+			jp.EstKeys = &estKeys
+			jp.EstTotalBytes = &estTotal
+		}
+		out = append(out, jp)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		panic(err)
+	}
+}
+
+// memoryReport キーをBatch件ずつpipelineでMEMORY USAGEして、集計結果をchReportに送る
+func memoryReport(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chReport chan<- report, opt reportOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+	rep := report{}
+
+	keys := make([]string, 0, opt.Batch)
+	flush := func() {
+		if len(keys) == 0 {
+			return
+		}
+		pipe := client.Pipeline()
+		cmds := make([]*redis.IntCmd, 0, len(keys))
+		for _, key := range keys {
+			cmds = append(cmds, pipe.MemoryUsage(ctx, key, opt.Samples))
+		}
+		// 個別のエラーは各cmdで見る
+		_, _ = pipe.Exec(ctx)
+
+		for j, cmd := range cmds {
+			bytes, err := cmd.Result()
+			if err == redis.Nil {
+				result.AddError("Key does not exist")
+				continue
+			} else if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+
+			rep.stat(redisutil.KeyPrefix(keys[j], opt.Delimiter, opt.Depth)).add(keys[j], uint64(bytes))
+		}
+		keys = keys[:0]
+	}
+
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]memory-report: %d\n", i, lc)
+		}
+
+		keys = append(keys, key)
+		if len(keys) >= opt.Batch {
+			flush()
+		}
+	}
+	flush()
+
+	fmt.Fprintf(os.Stderr, "[%02d]memory-report: %d, Elapsed: %s\n", i, lc, time.Since(from))
+
+	result.Lines = lc
+	chReport <- rep
+
+	return result
+}
@@ -0,0 +1,485 @@
+package main
+
+/*
+ * キーごとのOBJECT ENCODINGとOBJECT IDLETIME(LFUポリシーの場合はOBJECT FREQ)を出力し、
+ * プレフィックスごとにアイドル時間の分布を集計する。
+ * キーは入力ファイル、SCAN(全マスタ)、RANDOMKEYのいずれかから取る。
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+type prefixStat struct {
+	Total uint64
+	// OBJECT IDLETIMEの場合のアイドル時間の分布
+	Hist *redisutil.DurationHistogram
+	// OBJECT FREQの場合の合計と最大
+	FreqTotal uint64
+	FreqMax   int64
+}
+
+type report map[string]*prefixStat
+
+func (r report) stat(prefix string, bounds []time.Duration) *prefixStat {
+	st, ok := r[prefix]
+	if !ok {
+		st = &prefixStat{Hist: redisutil.NewDurationHistogram(bounds)}
+		r[prefix] = st
+	}
+	return st
+}
+
+func (st *prefixStat) merge(o *prefixStat) {
+	st.Total += o.Total
+	st.Hist.Merge(o.Hist)
+	st.FreqTotal += o.FreqTotal
+	if o.FreqMax > st.FreqMax {
+		st.FreqMax = o.FreqMax
+	}
+}
+
+func (r report) merge(o report, bounds []time.Duration) {
+	for prefix, ost := range o {
+		r.stat(prefix, bounds).merge(ost)
+	}
+}
+
+type statsOption struct {
+	Delimiter string
+	Depth     int
+	Bounds    []time.Duration
+	Batch     int
+	// idle, freq
+	Metric  string
+	MinIdle time.Duration
+	KeyOnly bool
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	mode := flag.String("mode", "scan", "How to get keys when no files specified {scan|random}")
+	samples := flag.Uint64("samples", 0, "Number of keys to sample(0=all scanned keys in scan mode)")
+	scanRate := flag.Float64("scan-rate", 1, "Fraction of scanned keys to be sampled in scan mode")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	match := flag.String("match", "", "Pattern of keys to scan in scan mode")
+	delimiter := flag.String("delimiter", ":", "Delimiter of key prefix")
+	depth := flag.Int("depth", 1, "Number of delimited elements to be a prefix")
+	buckets := flag.String("buckets", "1h,1d,7d,30d,90d", "Comma separated bounds of idle time buckets")
+	format := flag.String("format", "text", "Format of summary {text|json}")
+	metric := flag.String("metric", "auto", "{auto=freq if maxmemory-policy is LFU, otherwise idle|idle|freq}")
+	minIdle := flag.String("min-idle", "", "Output only keys idle for this duration or longer(ex. 90d)")
+	keyOnly := flag.Bool("key-only", false, "Output only keys so that the output can be fed into del")
+	out := flag.String("out", "object-", "path/to/prefix-of-file- for stats of each key")
+	outSplit := flag.Uint("out-split", 5, "Number of output files")
+	compress := flag.String("compress", "none", "{gzip|none=without compression}")
+	batch := flag.Int("batch", 100, "Number of keys to inspect in a pipeline")
+	worker := flag.Uint("worker", 8, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if len(files) == 0 {
+		switch *mode {
+		case "random":
+			if *samples == 0 {
+				log.Fatalf("*** --samples must be >= 1 in random mode")
+			}
+		case "scan":
+			if *scanRate <= 0 || *scanRate > 1 {
+				log.Fatalf("*** --scan-rate must be in (0, 1]")
+			}
+		default:
+			log.Fatalf("*** Unknown --mode: %s", *mode)
+		}
+	}
+
+	bounds, err := redisutil.ParseDurationBounds(*buckets)
+	if err != nil {
+		log.Fatalf("*** --buckets: %v", err)
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("*** Unknown --format: %s", *format)
+	}
+
+	if *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	var minIdleDuration time.Duration
+	if *minIdle != "" {
+		minIdleDuration, err = redisutil.ParseDuration(*minIdle)
+		if err != nil {
+			log.Fatalf("*** --min-idle: %v", err)
+		}
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *batch <= 0 {
+		log.Fatalf("*** --batch must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	ctx := context.Background()
+	resolvedMetric, err := resolveMetric(ctx, conn, *metric)
+	if err != nil {
+		log.Fatalf("*** --metric: %v", err)
+	}
+	if resolvedMetric == "freq" && minIdleDuration > 0 {
+		log.Fatalf("*** --min-idle can not be used with OBJECT FREQ")
+	}
+	fmt.Fprintf(os.Stderr, "Metric: %s\n", resolvedMetric)
+
+	opt := statsOption{
+		Delimiter: *delimiter,
+		Depth:     *depth,
+		Bounds:    bounds,
+		Batch:     *batch,
+		Metric:    resolvedMetric,
+		MinIdle:   minIdleDuration,
+		KeyOnly:   *keyOnly,
+	}
+
+	chOut := make(chan string, *outSplit)
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	wgOut := redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+
+	var lineCount int64
+	if len(files) > 0 {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	} else {
+		go func() {
+			defer close(chLine)
+			client := conn.NewClient()
+			defer client.Close()
+
+			var err error
+			if *mode == "random" {
+				lineCount, err = redisutil.SampleRandomKeys(ctx, client, *samples, chLine)
+			} else {
+				lineCount, _, err = redisutil.SampleScanKeys(ctx, client,
+					redisutil.ScanArgs{Match: *match, Count: *scanCount}, *scanRate, *samples, chLine)
+			}
+			if err != nil {
+				log.Fatalf("*** Sampling: %v", err)
+			}
+		}()
+	}
+
+	chResult := make(chan redisutil.Result, *worker)
+	chReport := make(chan report, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- objectStats(ctx, index, conn, chLine, chOut, chReport, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	totalReport := report{}
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+		totalReport.merge(<-chReport, bounds)
+	}
+
+	close(chOut)
+	wgOut.Wait()
+
+	if *format == "json" {
+		writeJSON(totalReport, opt)
+	} else {
+		writeText(totalReport, opt)
+	}
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+}
+
+// resolveMetric autoの場合はmaxmemory-policyがLFUならfreq、それ以外ならidleにする
+// LFUポリシーではOBJECT IDLETIMEが、それ以外ではOBJECT FREQがエラーになる
+func resolveMetric(ctx context.Context, conn redisutil.Connection, metric string) (string, error) {
+	switch metric {
+	case "idle", "freq":
+		return metric, nil
+	case "auto":
+	default:
+		return "", fmt.Errorf("unknown metric: %s", metric)
+	}
+
+	client := conn.NewClient()
+	defer client.Close()
+
+	v, err := client.ConfigGet(ctx, "maxmemory-policy").Result()
+	if err != nil {
+		return "", err
+	}
+	if len(v) == 2 {
+		if policy, ok := v[1].(string); ok && strings.Contains(policy, "lfu") {
+			return "freq", nil
+		}
+	}
+	return "idle", nil
+}
+
+// sortedPrefixes 件数の多い順に並べたプレフィックス
+func sortedPrefixes(r report) []string {
+	prefixes := make([]string, 0, len(r))
+	for prefix := range r {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := r[prefixes[i]], r[prefixes[j]]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	return prefixes
+}
+
+func writeText(r report, opt statsOption) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	total := &prefixStat{Hist: redisutil.NewDurationHistogram(opt.Bounds)}
+	if opt.Metric == "freq" {
+		fmt.Fprintln(w, "prefix\ttotal\tavg freq\tmax freq\t")
+		row := func(prefix string, st *prefixStat) {
+			fmt.Fprintf(w, "%s\t%d\t%.1f\t%d\t\n", prefix, st.Total, avgFreq(st), st.FreqMax)
+		}
+		for _, prefix := range sortedPrefixes(r) {
+			row(prefix, r[prefix])
+			total.merge(r[prefix])
+		}
+		row("(total)", total)
+		return
+	}
+
+	fmt.Fprint(w, "prefix\ttotal\t")
+	for _, l := range total.Hist.Labels() {
+		fmt.Fprint(w, l+"\t")
+	}
+	fmt.Fprintln(w)
+
+	row := func(prefix string, st *prefixStat) {
+		fmt.Fprintf(w, "%s\t%d\t", prefix, st.Total)
+		for _, c := range st.Hist.Counts {
+			fmt.Fprint(w, strconv.FormatUint(c, 10)+"\t")
+		}
+		fmt.Fprintln(w)
+	}
+	for _, prefix := range sortedPrefixes(r) {
+		row(prefix, r[prefix])
+		total.merge(r[prefix])
+	}
+	row("(total)", total)
+}
+
+func avgFreq(st *prefixStat) float64 {
+	if st.Total == 0 {
+		return 0
+	}
+	return float64(st.FreqTotal) / float64(st.Total)
+}
+
+type jsonPrefix struct {
+	Prefix  string   `json:"prefix"`
+	Total   uint64   `json:"total"`
+	Counts  []uint64 `json:"counts,omitempty"`
+	AvgFreq *float64 `json:"avg_freq,omitempty"`
+	MaxFreq *int64   `json:"max_freq,omitempty"`
+}
+
+type jsonReport struct {
+	Metric   string       `json:"metric"`
+	Buckets  []string     `json:"buckets,omitempty"`
+	Prefixes []jsonPrefix `json:"prefixes"`
+}
+
+func writeJSON(r report, opt statsOption) {
+	out := jsonReport{
+		Metric:   opt.Metric,
+		Prefixes: make([]jsonPrefix, 0, len(r)),
+	}
+	if opt.Metric == "idle" {
+		out.Buckets = redisutil.NewDurationHistogram(opt.Bounds).Labels()
+	}
+	for _, prefix := range sortedPrefixes(r) {
+		st := r[prefix]
+		p := jsonPrefix{
+			Prefix: prefix,
+			Total:  st.Total,
+		}
+		if opt.Metric == "idle" {
+			p.Counts = st.Hist.Counts
+		} else {
+			avg := avgFreq(st)
+			max := st.FreqMax
+			p.AvgFreq = &avg
+			p.MaxFreq = &max
+		}
+		out.Prefixes = append(out.Prefixes, p)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		panic(err)
+	}
+}
+
+// objectStats キーをBatch件ずつpipelineでOBJECT ENCODINGとOBJECT IDLETIME/FREQを調べて、
+// {key}\t{encoding}\t{idle seconds or freq}をchOutに、集計結果をchReportに送る
+func objectStats(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, chReport chan<- report, opt statsOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+	rep := report{}
+
+	// OBJECT IDLETIME or OBJECT FREQ
+	sub := "idletime"
+	if opt.Metric == "freq" {
+		sub = "freq"
+	}
+
+	keys := make([]string, 0, opt.Batch)
+	flush := func() {
+		if len(keys) == 0 {
+			return
+		}
+		pipe := client.Pipeline()
+		encCmds := make([]*redis.StringCmd, 0, len(keys))
+		metricCmds := make([]*redis.Cmd, 0, len(keys))
+		for _, key := range keys {
+			encCmds = append(encCmds, pipe.ObjectEncoding(ctx, key))
+			metricCmds = append(metricCmds, pipe.Do(ctx, "object", sub, key))
+		}
+		// 個別のエラーは各cmdで見る
+		_, _ = pipe.Exec(ctx)
+
+		for j, key := range keys {
+			enc, err := encCmds[j].Result()
+			if err == redis.Nil {
+				result.AddError("Key does not exist")
+				continue
+			} else if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+			n, err := metricCmds[j].Int64()
+			if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+
+			st := rep.stat(redisutil.KeyPrefix(key, opt.Delimiter, opt.Depth), opt.Bounds)
+			st.Total++
+			if opt.Metric == "idle" {
+				idle := time.Duration(n) * time.Second
+				st.Hist.Add(idle)
+				if idle < opt.MinIdle {
+					continue
+				}
+			} else {
+				st.FreqTotal += uint64(n)
+				if n > st.FreqMax {
+					st.FreqMax = n
+				}
+			}
+
+			if opt.KeyOnly {
+				chOut <- key
+			} else {
+				chOut <- key + "\t" + enc + "\t" + strconv.FormatInt(n, 10)
+			}
+		}
+		keys = keys[:0]
+	}
+
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]object-stats: %d\n", i, lc)
+		}
+
+		keys = append(keys, key)
+		if len(keys) >= opt.Batch {
+			flush()
+		}
+	}
+	flush()
+
+	fmt.Fprintf(os.Stderr, "[%02d]object-stats: %d, Elapsed: %s\n", i, lc, time.Since(from))
+
+	result.Lines = lc
+	chReport <- rep
+
+	return result
+}
@@ -3,6 +3,8 @@ package main
 /*
  * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
  * 改行コードは「必ずLF」であることを前提としている。
+ *
+ * 2列目は既定で期限の時刻。pttl --format=ttlの出力のような残り時間なら--line-ttl=ttlを指定する。
  */
 
 import (
@@ -10,11 +12,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	redisutil "github.com/tckz/redis-util"
 )
 
@@ -25,9 +28,19 @@ func main() {
 	showVersion := flag.Bool("version", false, "Show version")
 	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
 	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	lineTTL := flag.String("line-ttl", "expireat", "How to read the 2nd column {expireat=unixtime msec or RFC3339|ttl=remaining duration(ex. 90s, 1500=msec)}")
+	ttl := flag.String("ttl", "", "Set relative TTL to keys of each line instead of expire time in the 2nd column(ex. 90s, 12h, 7d, 1500=msec)")
+	persist := flag.Bool("persist", false, "Remove TTL from keys of each line(PERSIST)")
+	jitter := flag.String("jitter", "", "Add random duration within this window to each expiry(ex. 1h)")
+	nx := flag.Bool("nx", false, "Set expiry only when the key has no expiry(Redis 7+)")
+	xx := flag.Bool("xx", false, "Set expiry only when the key has an existing expiry(Redis 7+)")
+	gt := flag.Bool("gt", false, "Set expiry only when the new expiry is greater than current one(Redis 7+)")
+	lt := flag.Bool("lt", false, "Set expiry only when the new expiry is less than current one(Redis 7+)")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -39,8 +52,12 @@ func main() {
 		log.Fatalf("*** Files to load must be specified")
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -51,6 +68,57 @@ func main() {
 		log.Fatalf("*** --worker must be >= 1")
 	}
 
+	opt := expireOption{
+		Persist: *persist,
+		LineTTL: *lineTTL,
+	}
+	switch opt.LineTTL {
+	case "expireat", "ttl":
+	default:
+		log.Fatalf("*** Unknown --line-ttl: %s", opt.LineTTL)
+	}
+	if *ttl != "" {
+		d, err := redisutil.ParseDuration(*ttl)
+		if err != nil {
+			log.Fatalf("*** --ttl: %v", err)
+		}
+		if d <= 0 {
+			log.Fatalf("*** --ttl must be > 0")
+		}
+		opt.TTL = d
+	}
+	if *jitter != "" {
+		d, err := redisutil.ParseDuration(*jitter)
+		if err != nil {
+			log.Fatalf("*** --jitter: %v", err)
+		}
+		if d < 0 {
+			log.Fatalf("*** --jitter must be >= 0")
+		}
+		opt.Jitter = d
+	}
+
+	if opt.Persist && (opt.TTL > 0 || opt.Jitter > 0) {
+		log.Fatalf("*** --persist can not be used with --ttl or --jitter")
+	}
+
+	conds := 0
+	for _, e := range []struct {
+		on   bool
+		name string
+	}{{*nx, "nx"}, {*xx, "xx"}, {*gt, "gt"}, {*lt, "lt"}} {
+		if e.on {
+			opt.Condition = e.name
+			conds++
+		}
+	}
+	if conds > 1 {
+		log.Fatalf("*** --nx, --xx, --gt and --lt are mutually exclusive")
+	}
+	if conds > 0 && opt.Persist {
+		log.Fatalf("*** --persist can not be used with --nx, --xx, --gt or --lt")
+	}
+
 	chLine := make(chan string, *worker)
 	chFile := make(chan uint64)
 	from := time.Now()
@@ -81,7 +149,7 @@ func main() {
 	for i := uint(0); i < *worker; i++ {
 		index := i
 		go func() {
-			chResult <- pexpireat(ctx, index, nodes, chLine)
+			chResult <- pexpireat(ctx, index, conn, chLine, opt)
 		}()
 	}
 
@@ -93,18 +161,50 @@ func main() {
 	}
 
 	elapsed := time.Since(from)
-	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
-		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+// minExpireAt これより前の期限の時刻は、残り時間を誤って読んだものとみなす
+var minExpireAt = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type expireOption struct {
+	TTL     time.Duration
+	Persist bool
+	// 2列目の読み方。expireat, ttl
+	LineTTL string
+	Jitter  time.Duration
+	// 空またはnx, xx, gt, lt
+	Condition string
+}
+
+// jitter 期限が一斉に切れないよう[0, Jitter)のランダムな時間を返す
+func (o expireOption) jitter(r *rand.Rand) time.Duration {
+	if o.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(r.Int63n(int64(o.Jitter)))
+}
+
+// args PEXPIRE/PEXPIREATの引数を組み立てる
+// NX/XX/GT/LTはgo-redis v8にないのでDoで送る
+func (o expireOption) args(name string, key string, msec int64) []interface{} {
+	args := []interface{}{name, key, msec}
+	if o.Condition != "" {
+		args = append(args, o.Condition)
+	}
+	return args
 }
 
-func pexpireat(ctx context.Context, i uint, nodes []string, chLine <-chan string) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+func pexpireat(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, opt expireOption) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
 	from := time.Now()
 
 	result := redisutil.NewResult()
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
 
 	for line := range chLine {
 		lc++
@@ -112,25 +212,70 @@ func pexpireat(ctx context.Context, i uint, nodes []string, chLine <-chan string
 			fmt.Fprintf(os.Stderr, "[%02d]pexpireat: %d\n", i, lc)
 		}
 
-		// {key}    {expire unixtime msec}
-		token := strings.SplitN(line, "\t", 2)
-		if len(token) != 2 {
-			result.AddError("Number of tokens != 2")
-			continue
-		}
+		var cmd *redis.Cmd
+		if opt.Persist || opt.TTL > 0 {
+			// {key}    ...
+			// 2列目以降は無視するので、pttlの出力などをそのまま使える
+			key := strings.SplitN(line, "\t", 2)[0]
+			if opt.Persist {
+				cmd = client.Do(ctx, "persist", key)
+			} else {
+				cmd = client.Do(ctx, opt.args("pexpire", key, int64((opt.TTL+opt.jitter(r))/time.Millisecond))...)
+			}
+		} else {
+			// {key}    {expire unixtime msec or RFC3339}
+			// --line-ttl=ttlなら{key}    {remaining duration}
+			token := strings.SplitN(line, "\t", 2)
+			if len(token) != 2 {
+				result.AddError("Number of tokens != 2")
+				continue
+			}
 
-		unixTimeMsec, err := strconv.ParseInt(token[1], 10, 64)
-		if err != nil {
-			result.AddError(err.Error())
-			continue
+			// pttlの出力の-1(期限なし)と-2(キーなし)をそのまま戻せるようにする
+			if token[1] == "-2" {
+				result.AddCount("missing", 1)
+				continue
+			} else if token[1] == "-1" {
+				cmd = client.Do(ctx, "persist", token[0])
+			} else if opt.LineTTL == "ttl" {
+				d, err := redisutil.ParseDuration(token[1])
+				if err != nil {
+					result.AddError(err.Error())
+					continue
+				}
+				if d <= 0 {
+					result.AddError("TTL must be > 0")
+					continue
+				}
+				cmd = client.Do(ctx, opt.args("pexpire", token[0], int64((d+opt.jitter(r))/time.Millisecond))...)
+			} else {
+				tm, err := redisutil.ParseExpireAt(token[1])
+				if err != nil {
+					result.AddError(err.Error())
+					continue
+				}
+				// 残り時間を期限の時刻として読むと1970年代になり、キーがすぐに消えてしまう
+				if tm.Before(minExpireAt) {
+					result.AddError("Expire time is too far in the past(use --line-ttl=ttl for remaining duration)")
+					continue
+				}
+
+				unixTimeMsec := redisutil.TimeToUnixMsec(tm.Add(opt.jitter(r)))
+				cmd = client.Do(ctx, opt.args("pexpireat", token[0], unixTimeMsec)...)
+			}
 		}
 
-		tm := time.Unix(unixTimeMsec/1000, (unixTimeMsec%1000)*int64(time.Millisecond))
-		_, err = client.PExpireAt(ctx, token[0], tm).Result()
+		n, err := cmd.Int64()
 		if err != nil {
 			result.AddError(err.Error())
 			continue
 		}
+		if n == 1 {
+			result.AddCount("applied", 1)
+		} else {
+			// キーが存在しないか、条件を満たさなかった
+			result.AddCount("not applied", 1)
+		}
 	}
 
 	elapsed := time.Since(from)
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	redisutil "github.com/tckz/redis-util"
 )
 
@@ -27,9 +28,15 @@ func main() {
 	compress := flag.String("compress", "none", "{gzip|none=without compression}")
 	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
 	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	format := flag.String("format", "expireat", "{expireat=unixtime msec to expire|ttl=remaining msec|rfc3339=time to expire}")
+	tz := flag.String("tz", "Local", "Timezone for --format=rfc3339(ex. UTC, Asia/Tokyo)")
+	missingRow := flag.Bool("missing-row", false, "Output -2 for keys which do not exist instead of counting them as errors")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	conn.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -41,8 +48,12 @@ func main() {
 		log.Fatalf("*** Files to load must be specified")
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -57,6 +68,24 @@ func main() {
 		log.Fatalf("*** --worker must be >= 1")
 	}
 
+	switch *format {
+	case "expireat", "ttl", "rfc3339":
+	default:
+		log.Fatalf("*** Unknown --format: %s", *format)
+	}
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("*** --tz: %v", err)
+	}
+
+	opt := pttlOption{
+		Format:          *format,
+		Location:        loc,
+		MissingRow:      *missingRow,
+		ReadFromReplica: conn.ReadFromReplica,
+	}
+
 	chOut := make(chan string, *outSplit)
 	chLine := make(chan string, *worker)
 	chFile := make(chan uint64)
@@ -91,7 +120,7 @@ func main() {
 		// 入力行を受け取ってredisからgetする
 		index := i
 		go func() {
-			chResult <- pttl(ctx, index, nodes, chLine, chOut)
+			chResult <- pttl(ctx, index, conn, chLine, chOut, opt)
 		}()
 	}
 
@@ -110,11 +139,68 @@ func main() {
 		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
 }
 
-const ttlNotExist = time.Millisecond * -2
+// go-redisのPTTLは-1/-2を単位なしのDurationにするので、msecではなく生の値で比較する
+const ttlNotExist = -2
 const neverExpire = "-1"
+const notExist = "-2"
+
+type pttlOption struct {
+	// expireat, ttl, rfc3339
+	Format          string
+	Location        *time.Location
+	MissingRow      bool
+	ReadFromReplica bool
+}
 
-func pttl(ctx context.Context, i uint, nodes []string, chLine <-chan string, chOut chan<- string) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+// getPTTL PTTL(msec)と、期限の時刻を求める基準となるサーバの現在時刻(unixtime msec)を返す
+func getPTTL(ctx context.Context, client redis.UniversalClient, key string, opt pttlOption) (int64, int64, error) {
+	if opt.Format == "ttl" {
+		d, err := client.PTTL(ctx, key).Result()
+		if err != nil {
+			return 0, 0, err
+		}
+		if d < 0 {
+			return int64(d), 0, nil
+		}
+		return int64(d / time.Millisecond), 0, nil
+	}
+
+	if opt.ReadFromReplica {
+		return pttlOnReplica(ctx, client, key)
+	}
+	return redisutil.PTTLWithServerTime(ctx, client, key)
+}
+
+// pttlOnReplica PTTLとTIMEを、キーを読むレプリカにpipelineで続けて送る
+func pttlOnReplica(ctx context.Context, client redis.UniversalClient, key string) (int64, int64, error) {
+	node := client
+	if cc, ok := client.(*redis.ClusterClient); ok {
+		c, err := cc.SlaveForKey(ctx, key)
+		if err != nil {
+			return 0, 0, err
+		}
+		node = c
+	}
+
+	var pttlCmd *redis.DurationCmd
+	var timeCmd *redis.TimeCmd
+	_, err := node.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pttlCmd = pipe.PTTL(ctx, key)
+		timeCmd = pipe.Time(ctx)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	d := pttlCmd.Val()
+	if d < 0 {
+		return int64(d), 0, nil
+	}
+	return int64(d / time.Millisecond), redisutil.TimeToUnixMsec(timeCmd.Val()), nil
+}
+
+func pttl(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, opt pttlOption) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
@@ -128,22 +214,31 @@ func pttl(ctx context.Context, i uint, nodes []string, chLine <-chan string, chO
 			fmt.Fprintf(os.Stderr, "[%02d]pttl: %d\n", i, lc)
 		}
 
-		d, err := client.PTTL(ctx, key).Result()
+		ms, now, err := getPTTL(ctx, client, key, opt)
 		if err != nil {
 			result.AddError(err.Error())
 			continue
-		} else if d == ttlNotExist {
-			result.AddError("Key does not exist")
-			continue
 		}
 
-		ms := neverExpire
-		if d >= 0 {
-			expireAtMsec := time.Now().Add(d).UnixNano() / int64(time.Millisecond)
-			ms = strconv.FormatInt(expireAtMsec, 10)
+		var s string
+		switch {
+		case ms == ttlNotExist:
+			if !opt.MissingRow {
+				result.AddError("Key does not exist")
+				continue
+			}
+			s = notExist
+		case ms < 0:
+			s = neverExpire
+		case opt.Format == "ttl":
+			s = strconv.FormatInt(ms, 10)
+		case opt.Format == "rfc3339":
+			s = redisutil.UnixMsecToTime(now + ms).In(opt.Location).Format(time.RFC3339Nano)
+		default:
+			s = strconv.FormatInt(now+ms, 10)
 		}
 
-		chOut <- key + "\t" + ms
+		chOut <- key + "\t" + s
 	}
 
 	elapsed := time.Since(from)
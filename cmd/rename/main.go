@@ -0,0 +1,244 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ *
+ * 入力行は{src}\t{dst}か、書き換え規則を指定した場合は{src}のみ。
+ * ファイルを指定しなければ全マスタをSCANしたキーに書き換え規則を適用する。
+ * 書き換え後のキーが再び規則に一致する場合は、何度も書き換えられないようそのキーは飛ばす。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+type renameOption struct {
+	// nilなら入力行の2列目をdstにする
+	Rewriter  *redisutil.KeyRewriter
+	Overwrite bool
+	DryRun    bool
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	fromPrefix := flag.String("from-prefix", "", "Prefix of keys to be replaced")
+	toPrefix := flag.String("to-prefix", "", "New prefix of keys")
+	regex := flag.String("regex", "", "Regular expression of keys to be rewritten")
+	repl := flag.String("repl", "", "Replacement for --regex. $1 etc. refer to submatches(ex. user:{$1}:$2)")
+	match := flag.String("match", "", "Pattern of keys to scan when no files specified(default: --from-prefix*, required with --regex)")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	overwrite := flag.Bool("overwrite", false, "Overwrite existing destination keys. By default keys are renamed only when the destination does not exist")
+	dryRun := flag.Bool("dry-run", false, "Only print {src}\\t{dst} to stdout without renaming")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	opt := renameOption{
+		Overwrite: *overwrite,
+		DryRun:    *dryRun,
+	}
+
+	var err error
+	if *regex != "" {
+		if *fromPrefix != "" {
+			log.Fatalf("*** --regex can not be used with --from-prefix")
+		}
+		opt.Rewriter, err = redisutil.NewRegexpRewriter(*regex, *repl)
+		if err != nil {
+			log.Fatalf("*** --regex: %v", err)
+		}
+	} else if *fromPrefix != "" {
+		// 一方が他方のプレフィックスだと、リネーム後のキーが再び--from-prefixにかかることがある
+		if strings.HasPrefix(*toPrefix, *fromPrefix) || strings.HasPrefix(*fromPrefix, *toPrefix) {
+			log.Fatalf("*** --from-prefix and --to-prefix must not be a prefix of the other")
+		}
+		opt.Rewriter, err = redisutil.NewPrefixRewriter(*fromPrefix, *toPrefix)
+		if err != nil {
+			log.Fatalf("*** --from-prefix: %v", err)
+		}
+	}
+
+	if len(files) == 0 {
+		if opt.Rewriter == nil {
+			log.Fatalf("*** --from-prefix or --regex must be specified when no files specified")
+		}
+		if *match == "" {
+			// 正規表現ではキーの範囲を絞れず、全てのキーをSCANすることになるので明示させる
+			if *regex != "" {
+				log.Fatalf("*** --match must be specified with --regex when no files specified(--match='*' for all keys)")
+			}
+			*match = opt.Rewriter.ScanPattern()
+		}
+	}
+
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	ctx := context.Background()
+	var lineCount int64
+	if len(files) > 0 {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	} else {
+		go func() {
+			defer close(chLine)
+			client := conn.NewClient()
+			defer client.Close()
+
+			var err error
+			lineCount, _, err = redisutil.SampleScanKeys(ctx, client,
+				redisutil.ScanArgs{Match: *match, Count: *scanCount}, 1, 0, chLine)
+			if err != nil {
+				log.Fatalf("*** Scan: %v", err)
+			}
+		}()
+	}
+
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- rename(ctx, index, conn, chLine, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+func rename(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, opt renameOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]rename: %d\n", i, lc)
+		}
+
+		// {src}    {dst}
+		// {src}のみなら書き換え規則でdstを決める
+		token := strings.SplitN(line, "\t", 2)
+		src := token[0]
+		var dst string
+		if len(token) == 2 {
+			dst = token[1]
+		} else if opt.Rewriter != nil {
+			var ok bool
+			dst, ok = opt.Rewriter.Rewrite(src)
+			if !ok {
+				result.AddCount("not matched", 1)
+				continue
+			}
+			if !opt.Rewriter.Stable(dst) {
+				result.AddCount("skipped by unstable rewrite", 1)
+				continue
+			}
+		} else {
+			result.AddError("Number of tokens != 2")
+			continue
+		}
+
+		if src == dst {
+			result.AddCount("same key", 1)
+			continue
+		}
+
+		if opt.DryRun {
+			fmt.Fprintf(os.Stdout, "%s\t%s\n", src, dst)
+			result.AddCount("would rename", 1)
+			continue
+		}
+
+		renamed, err := redisutil.RenameKey(ctx, client, src, dst, !opt.Overwrite)
+		if err == redis.Nil {
+			result.AddCount("not found", 1)
+			continue
+		} else if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+		if renamed {
+			result.AddCount("renamed", 1)
+		} else {
+			result.AddCount("destination exists", 1)
+		}
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]rename: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
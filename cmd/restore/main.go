@@ -0,0 +1,153 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ *
+ * 入力はdel --backupの出力({key}\t{expire unixtime msec or -1}\t{base64 of dump})
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	replace := flag.Bool("replace", false, "Replace existing keys(RESTORE ... REPLACE)")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	chLine := make(chan string, *worker)
+	chFile := make(chan uint64)
+	from := time.Now()
+
+	var lineCount int64
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- restore(ctx, index, conn, chLine, *replace)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+func restore(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, replace bool) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]restore: %d\n", i, lc)
+		}
+
+		key, expireAtMsec, dump, err := redisutil.ParseDumpLine(line)
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+
+		if expireAtMsec != redisutil.NoExpire && expireAtMsec <= redisutil.TimeToUnixMsec(time.Now()) {
+			// バックアップ後に期限が過ぎたキーは戻さない
+			result.AddCount("expired", 1)
+			continue
+		}
+
+		err = redisutil.RestoreKey(ctx, client, key, expireAtMsec, dump, replace)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "BUSYKEY") {
+				result.AddCount("already exists", 1)
+				continue
+			}
+			result.AddError(err.Error())
+			continue
+		}
+		result.AddCount("restored", 1)
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]restore: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
@@ -0,0 +1,199 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	command := flag.String("command", "", "Command template. {n} is replaced with n-th tab separated column(ex. HINCRBY {1} views {2})")
+	reply := flag.Bool("reply", false, "Write replies to output files")
+	withoutKey := flag.Bool("without-key", false, "Whether output with the 1st column or not")
+	nilMarker := flag.String("nil-marker", "(nil)", "Reply written for nil replies with --reply, so that output lines correspond to input lines")
+	out := flag.String("out", "out-", "path/to/prefix-of-file- with --reply")
+	outSplit := flag.Uint("out-split", 5, "Number of output files with --reply")
+	compress := flag.String("compress", "none", "{gzip|none=without compression} with --reply")
+	worker := flag.Uint("worker", 32, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	tmpl, err := redisutil.ParseCommandTemplate(*command)
+	if err != nil {
+		log.Fatalf("*** --command: %v", err)
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *reply && *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	chLine := make(chan string, *worker)
+	chFile := make(chan uint64)
+	from := time.Now()
+
+	var chOut chan string
+	var wgOut *sync.WaitGroup
+	if *reply {
+		chOut = make(chan string, *outSplit)
+		wgOut = redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+	}
+
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	var lineCount int64
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	opt := runOption{
+		WithoutKey: *withoutKey,
+		NilMarker:  *nilMarker,
+	}
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- run(ctx, index, conn, tmpl, chLine, chOut, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	if chOut != nil {
+		close(chOut)
+		wgOut.Wait()
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+type runOption struct {
+	WithoutKey bool
+	// nilの応答の代わりに出力する文字列
+	NilMarker string
+}
+
+// run 入力行をテンプレートに埋め込んだコマンドを実行する。chOutがnilなら応答は捨てる
+func run(ctx context.Context, i uint, conn redisutil.Connection, tmpl *redisutil.CommandTemplate, chLine <-chan string, chOut chan<- string, opt runOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]run: %d\n", i, lc)
+		}
+
+		columns := strings.Split(line, "\t")
+		args, err := tmpl.Expand(columns)
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+
+		var s string
+		v, err := client.Do(ctx, args...).Result()
+		if err == redis.Nil {
+			// 入力行と出力行が対応するよう、nilでも行を出す
+			result.AddCount("nil replies", 1)
+			s = opt.NilMarker
+		} else if err != nil {
+			result.AddError(err.Error())
+			continue
+		} else {
+			s = redisutil.FormatReply(v)
+		}
+
+		if chOut == nil {
+			continue
+		}
+
+		if opt.WithoutKey {
+			chOut <- s
+		} else {
+			chOut <- columns[0] + "\t" + s
+		}
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]run: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
@@ -0,0 +1,258 @@
+package main
+
+/*
+ * 全マスタをSCANして見つかったキーに、そのまま更新系の操作を行う。
+ * 中間ファイルを介さずscanとdel等をつなぐためのもの。
+ * --stateを指定するとマスタごとのSCANのカーソルを記録し、中断しても同じ--stateで再実行すれば続きから処理する。
+ * カーソルはSCAN1回分のキーを処理し終えてから記録するので、再開しても取りこぼさない。
+ * 中断時に処理中だった分はもう一度処理する(expireは期限を設定し直す)。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+type actOption struct {
+	Action string
+	TTL    time.Duration
+	// rename-prefixで置き換える前後のプレフィックス
+	FromPrefix string
+	ToPrefix   string
+	DryRun     bool
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	action := flag.String("action", "", "{unlink|expire|persist|touch|rename-prefix}")
+	match := flag.String("match", "", "Pattern of keys to scan")
+	keyType := flag.String("type", "", "Type of keys to scan(ex. string, hash)")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	ttl := flag.String("ttl", "", "TTL to set for --action=expire(ex. 90s, 12h, 7d, 1500=msec)")
+	fromPrefix := flag.String("from-prefix", "", "Prefix of keys to be replaced for --action=rename-prefix")
+	toPrefix := flag.String("to-prefix", "", "New prefix of keys for --action=rename-prefix")
+	rate := flag.Float64("rate", 0, "Max number of keys to act on per second(0=unlimited)")
+	dryRun := flag.Bool("dry-run", false, "Only count keys to be acted on")
+	state := flag.String("state", "", "File to record scan cursors of each master. Resume from the cursors if the file exists")
+	worker := flag.Uint("worker", 8, "Number of worker goroutines")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	opt := actOption{
+		Action:     *action,
+		FromPrefix: *fromPrefix,
+		ToPrefix:   *toPrefix,
+		DryRun:     *dryRun,
+	}
+
+	switch opt.Action {
+	case "unlink", "persist", "touch":
+	case "expire":
+		if *ttl == "" {
+			log.Fatalf("*** --ttl must be specified for --action=expire")
+		}
+		d, err := redisutil.ParseDuration(*ttl)
+		if err != nil {
+			log.Fatalf("*** --ttl: %v", err)
+		}
+		if d <= 0 {
+			log.Fatalf("*** --ttl must be > 0")
+		}
+		opt.TTL = d
+	case "rename-prefix":
+		if opt.FromPrefix == "" {
+			log.Fatalf("*** --from-prefix must be specified for --action=rename-prefix")
+		}
+		// 一方が他方のプレフィックスだと、リネーム後のキーが再び--from-prefixにかかることがある
+		if strings.HasPrefix(opt.ToPrefix, opt.FromPrefix) || strings.HasPrefix(opt.FromPrefix, opt.ToPrefix) {
+			log.Fatalf("*** --from-prefix and --to-prefix must not be a prefix of the other")
+		}
+		if *match == "" {
+			*match = redisutil.EscapePattern(opt.FromPrefix) + "*"
+		}
+	case "":
+		log.Fatalf("*** --action must be specified")
+	default:
+		log.Fatalf("*** Unknown --action: %s", opt.Action)
+	}
+
+	// 全てのキーが対象になるなら、--match='*'で明示させる
+	if *match == "" && !opt.DryRun {
+		log.Fatalf("*** --match must be specified(--match='*' for all keys)")
+	}
+
+	var scanState *redisutil.ScanState
+	if *state != "" {
+		var err error
+		scanState, err = redisutil.LoadScanState(*state)
+		if err != nil {
+			log.Fatalf("*** --state: %v", err)
+		}
+	}
+
+	chKey := make(chan scannedKey, *worker)
+	from := time.Now()
+
+	ctx := context.Background()
+	go func() {
+		defer close(chKey)
+		client := conn.NewClient()
+		defer client.Close()
+
+		var limiter *redisutil.RateLimiter
+		if !opt.DryRun {
+			limiter = redisutil.NewRateLimiter(*rate)
+		}
+		// マスタごとに並行して呼ばれるので、件数はchKeyを読む側で数える
+		err := redisutil.ScanBatches(ctx, client, redisutil.ScanArgs{Match: *match, Count: *scanCount, Type: *keyType, State: scanState},
+			func(keys []string) error {
+				// workerが処理し終えるまで待ってから、カーソルを記録させる
+				wg := &sync.WaitGroup{}
+				wg.Add(len(keys))
+				for _, key := range keys {
+					if limiter != nil {
+						limiter.Wait()
+					}
+					chKey <- scannedKey{Key: key, wg: wg}
+				}
+				wg.Wait()
+				return nil
+			})
+		if err != nil {
+			log.Fatalf("*** Scan: %v", err)
+		}
+	}()
+
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- act(ctx, index, conn, chKey, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Keys: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+// scannedKey 処理し終えたらDoneする
+type scannedKey struct {
+	Key string
+	wg  *sync.WaitGroup
+}
+
+func act(ctx context.Context, i uint, conn redisutil.Connection, chKey <-chan scannedKey, opt actOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for sk := range chKey {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]%s: %d\n", i, opt.Action, lc)
+		}
+
+		count, err := actOn(ctx, client, sk.Key, opt)
+		sk.wg.Done()
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+		result.AddCount(count, 1)
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]%s: %d, Elapsed: %s\n", i, opt.Action, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
+
+// actOn キーに操作を行い、結果を数える名前を返す
+func actOn(ctx context.Context, client redis.UniversalClient, key string, opt actOption) (string, error) {
+	if opt.Action == "rename-prefix" && !strings.HasPrefix(key, opt.FromPrefix) {
+		// --matchを独自に指定した場合
+		return "skipped by prefix", nil
+	}
+
+	if opt.DryRun {
+		return "would " + opt.Action, nil
+	}
+
+	var applied bool
+	var err error
+	switch opt.Action {
+	case "unlink":
+		var n int64
+		n, err = client.Unlink(ctx, key).Result()
+		applied = n == 1
+	case "expire":
+		applied, err = client.PExpire(ctx, key, opt.TTL).Result()
+	case "persist":
+		applied, err = client.Persist(ctx, key).Result()
+	case "touch":
+		var n int64
+		n, err = client.Touch(ctx, key).Result()
+		applied = n == 1
+	case "rename-prefix":
+		// 既存のキーは上書きしない
+		applied, err = redisutil.RenameKey(ctx, client, key, opt.ToPrefix+strings.TrimPrefix(key, opt.FromPrefix), true)
+		if err == redis.Nil {
+			// SCANの後に消えた
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if applied {
+		return "applied", nil
+	}
+	// SCANの後にキーが消えたか、操作の対象外だった(期限なしのキーへのpersist等)
+	return "not applied", nil
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	redisutil "github.com/tckz/redis-util"
@@ -19,20 +20,27 @@ func main() {
 	optVersion := flag.Bool("version", false, "Show version")
 	optCursor := flag.Uint64("cursor", 0, "Beginning of cursor")
 	optMatch := flag.String("match", "", "match")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	conn.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 
 	if *optVersion {
 		fmt.Printf("%s\n", version)
 		return
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
-	cl := redisutil.NewRedisClient(nodes)
+	cl := conn.NewClient()
 	defer cl.Close()
 
 	from := time.Now()
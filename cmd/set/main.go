@@ -7,8 +7,10 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	redisutil "github.com/tckz/redis-util"
 )
@@ -22,9 +24,21 @@ func main() {
 	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
 	randomKeys := flag.Uint("random", 0, "Number of key&values to generate")
 	randomPrefix := flag.String("random-prefix", "rand-", "Prefix of random generated key")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	nx := flag.Bool("nx", false, "Only set the key if it does not exist(SET NX)")
+	xx := flag.Bool("xx", false, "Only set the key if it already exists(SET XX)")
+	ttl := flag.String("ttl", "", "TTL of all keys(ex. 90s, 12h, 7d, 1500=msec)")
+	expireAt := flag.String("expire-at", "", "Expire time of all keys(unixtime msec or RFC3339)")
+	keepTTL := flag.Bool("keepttl", false, "Retain the TTL of existing keys(SET KEEPTTL)")
+	lineTTL := flag.String("line-ttl", "none", "Read TTL from the 2nd column of each line as {key}\\t{ttl}\\t{value} {none|ttl|expireat}")
+	get := flag.Bool("get", false, "Write previous values to output files(SET GET)")
+	out := flag.String("out", "out-", "path/to/prefix-of-file- with --get")
+	outSplit := flag.Uint("out-split", 5, "Number of output files with --get")
+	compress := flag.String("compress", "none", "{gzip|none=without compression} with --get")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -36,8 +50,12 @@ func main() {
 		log.Fatalf("*** Files to load must be specified")
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -48,9 +66,69 @@ func main() {
 		log.Fatalf("*** --worker must be >= 1")
 	}
 
+	if *nx && *xx {
+		log.Fatalf("*** --nx and --xx are mutually exclusive")
+	}
+
+	opt := setOption{
+		NX:      *nx,
+		XX:      *xx,
+		KeepTTL: *keepTTL,
+		LineTTL: *lineTTL,
+		Get:     *get,
+	}
+
+	expireOpts := 0
+	if *ttl != "" {
+		d, err := redisutil.ParseDuration(*ttl)
+		if err != nil {
+			log.Fatalf("*** --ttl: %v", err)
+		}
+		if d <= 0 {
+			log.Fatalf("*** --ttl must be > 0")
+		}
+		opt.TTL = d
+		expireOpts++
+	}
+	if *expireAt != "" {
+		tm, err := redisutil.ParseExpireAt(*expireAt)
+		if err != nil {
+			log.Fatalf("*** --expire-at: %v", err)
+		}
+		opt.ExpireAt = tm
+		expireOpts++
+	}
+	if *keepTTL {
+		expireOpts++
+	}
+	switch *lineTTL {
+	case "none":
+	case "ttl", "expireat":
+		if *randomKeys > 0 {
+			log.Fatalf("*** --line-ttl can not be used with --random")
+		}
+		expireOpts++
+	default:
+		log.Fatalf("*** Unknown --line-ttl: %s", *lineTTL)
+	}
+	if expireOpts > 1 {
+		log.Fatalf("*** Only one of --ttl, --expire-at, --keepttl and --line-ttl can be specified")
+	}
+
+	if *get && *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
 	chLine := make(chan string, *worker)
 	from := time.Now()
 
+	var chOut chan string
+	var wgOut *sync.WaitGroup
+	if *get {
+		chOut = make(chan string, *outSplit)
+		wgOut = redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+	}
+
 	var lineCount int64
 	if *randomKeys > 0 {
 		go func() {
@@ -88,7 +166,7 @@ func main() {
 	for i := uint(0); i < *worker; i++ {
 		index := i
 		go func() {
-			chResult <- set(ctx, index, nodes, chLine)
+			chResult <- set(ctx, index, conn, chLine, chOut, opt)
 		}()
 	}
 
@@ -99,13 +177,52 @@ func main() {
 		totalResult = totalResult.Combine(result)
 	}
 
+	if chOut != nil {
+		close(chOut)
+		wgOut.Wait()
+	}
+
 	elapsed := time.Since(from)
-	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
-		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+type setOption struct {
+	NX       bool
+	XX       bool
+	TTL      time.Duration
+	ExpireAt time.Time
+	KeepTTL  bool
+	// none, ttl, expireat
+	LineTTL string
+	Get     bool
+}
+
+// args SETコマンドの引数を組み立てる
+// go-redisのSetArgsはEXATを秒単位でしか送らないので、PX/PXATで自前で組み立てる
+func (o setOption) args(key string, value string, ttl time.Duration, expireAt time.Time) []interface{} {
+	args := make([]interface{}, 0, 8)
+	args = append(args, "set", key, value)
+	if ttl > 0 {
+		args = append(args, "px", int64(ttl/time.Millisecond))
+	} else if !expireAt.IsZero() {
+		args = append(args, "pxat", redisutil.TimeToUnixMsec(expireAt))
+	} else if o.KeepTTL {
+		args = append(args, "keepttl")
+	}
+	if o.NX {
+		args = append(args, "nx")
+	} else if o.XX {
+		args = append(args, "xx")
+	}
+	if o.Get {
+		args = append(args, "get")
+	}
+	return args
 }
 
-func set(ctx context.Context, i uint, nodes []string, chLine <-chan string) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+func set(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, opt setOption) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
@@ -119,18 +236,53 @@ func set(ctx context.Context, i uint, nodes []string, chLine <-chan string) redi
 			fmt.Fprintf(os.Stderr, "[%02d]set: %d\n", i, lc)
 		}
 
-		// {key}    {value}
-		token := strings.SplitN(line, "\t", 2)
-		if len(token) != 2 {
-			result.AddError("Number of tokens != 2")
-			continue
+		ttl, expireAt := opt.TTL, opt.ExpireAt
+		var key, value string
+		if opt.LineTTL == "none" {
+			// {key}    {value}
+			token := strings.SplitN(line, "\t", 2)
+			if len(token) != 2 {
+				result.AddError("Number of tokens != 2")
+				continue
+			}
+			key, value = token[0], token[1]
+		} else {
+			// {key}    {ttl}	{value}
+			token := strings.SplitN(line, "\t", 3)
+			if len(token) != 3 {
+				result.AddError("Number of tokens != 3")
+				continue
+			}
+			var err error
+			ttl, expireAt, err = redisutil.ParseLineTTL(opt.LineTTL, token[1])
+			if err == redisutil.ErrLineTTLNotExist {
+				result.AddCount("missing", 1)
+				continue
+			} else if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+			key, value = token[0], token[2]
 		}
 
-		_, err := client.Set(ctx, token[0], token[1], 0).Result()
-		if err != nil {
+		prev, err := client.Do(ctx, opt.args(key, value, ttl, expireAt)...).Text()
+		if err == redis.Nil {
+			if opt.Get {
+				// 元の値がなかった
+				result.AddCount("no previous value", 1)
+			} else {
+				// NX/XXの条件を満たさなかった
+				result.AddCount("not set", 1)
+			}
+			continue
+		} else if err != nil {
 			result.AddError(err.Error())
 			continue
 		}
+
+		if opt.Get {
+			chOut <- key + "\t" + prev
+		}
 	}
 
 	elapsed := time.Since(from)
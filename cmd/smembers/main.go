@@ -0,0 +1,183 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	chunk := flag.Int64("chunk", 1000, "Max number of members per output line")
+	scanCount := flag.Int64("scan-count", 1000, "SSCAN count at once")
+	out := flag.String("out", "out-", "path/to/prefix-of-file-")
+	outSplit := flag.Uint("out-split", 5, "Number of output files")
+	compress := flag.String("compress", "none", "{gzip|none=without compression}")
+	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	conn.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	if *chunk <= 0 {
+		log.Fatalf("*** --chunk must be >= 1")
+	}
+
+	chOut := make(chan string, *outSplit)
+	chLine := make(chan string, *worker)
+	chFile := make(chan uint64)
+	from := time.Now()
+
+	wgOut := redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	var lineCount int64
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		// 入力行を受け取ってredisからsscanする
+		index := i
+		go func() {
+			chResult <- smembers(ctx, index, conn, chLine, chOut, *chunk, *scanCount)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	close(chOut)
+	wgOut.Wait()
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
+}
+
+// smembers SMEMBERSは巨大なSETでサーバを止めてしまうのでSSCANで少しずつ取り出し、
+// saddの入力と同じ{key}\t{member}...の形式でchunk件ずつ出力する
+// SSCANの性質上、同じメンバが重複して出力されることがある
+func smembers(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, chunk int64, scanCount int64) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	members := make([]string, 0, chunk)
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]smembers: %d\n", i, lc)
+		}
+
+		members = members[:0]
+		found := false
+		var cursor uint64
+		for {
+			var page []string
+			var err error
+			page, cursor, err = client.SScan(ctx, key, cursor, "", scanCount).Result()
+			if err != nil {
+				result.AddError(err.Error())
+				break
+			}
+			for _, m := range page {
+				found = true
+				members = append(members, m)
+				if int64(len(members)) >= chunk {
+					chOut <- key + "\t" + strings.Join(members, "\t")
+					members = members[:0]
+				}
+			}
+			if cursor == 0 {
+				if !found {
+					result.AddError("Key does not exist")
+				}
+				break
+			}
+		}
+
+		if len(members) > 0 {
+			chOut <- key + "\t" + strings.Join(members, "\t")
+		}
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]smembers: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
@@ -0,0 +1,354 @@
+package main
+
+/*
+ * キーのプレフィックスごとに、期限切れまでの時間の分布を集計する。
+ * キーは入力ファイル、SCAN(全マスタ)、RANDOMKEYのいずれかから取る。
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+type prefixStat struct {
+	Total    uint64
+	NoExpire uint64
+	Hist     *redisutil.DurationHistogram
+}
+
+type report map[string]*prefixStat
+
+func (r report) stat(prefix string, bounds []time.Duration) *prefixStat {
+	st, ok := r[prefix]
+	if !ok {
+		st = &prefixStat{Hist: redisutil.NewDurationHistogram(bounds)}
+		r[prefix] = st
+	}
+	return st
+}
+
+func (r report) merge(o report, bounds []time.Duration) {
+	for prefix, ost := range o {
+		st := r.stat(prefix, bounds)
+		st.Total += ost.Total
+		st.NoExpire += ost.NoExpire
+		st.Hist.Merge(ost.Hist)
+	}
+}
+
+type reportOption struct {
+	Delimiter string
+	Depth     int
+	Bounds    []time.Duration
+	Batch     int
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	mode := flag.String("mode", "scan", "How to get keys when no files specified {scan|random}")
+	samples := flag.Uint64("samples", 0, "Number of keys to sample(0=all scanned keys in scan mode)")
+	scanRate := flag.Float64("scan-rate", 1, "Fraction of scanned keys to be sampled in scan mode")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	match := flag.String("match", "", "Pattern of keys to scan in scan mode")
+	delimiter := flag.String("delimiter", ":", "Delimiter of key prefix")
+	depth := flag.Int("depth", 1, "Number of delimited elements to be a prefix")
+	buckets := flag.String("buckets", "1m,1h,1d,7d,30d", "Comma separated bounds of time-to-expiry buckets")
+	format := flag.String("format", "text", "{text|json}")
+	batch := flag.Int("batch", 100, "Number of PTTL to send in a pipeline")
+	worker := flag.Uint("worker", 8, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if len(files) == 0 {
+		switch *mode {
+		case "random":
+			if *samples == 0 {
+				log.Fatalf("*** --samples must be >= 1 in random mode")
+			}
+		case "scan":
+			if *scanRate <= 0 || *scanRate > 1 {
+				log.Fatalf("*** --scan-rate must be in (0, 1]")
+			}
+		default:
+			log.Fatalf("*** Unknown --mode: %s", *mode)
+		}
+	}
+
+	bounds, err := redisutil.ParseDurationBounds(*buckets)
+	if err != nil {
+		log.Fatalf("*** --buckets: %v", err)
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("*** Unknown --format: %s", *format)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *batch <= 0 {
+		log.Fatalf("*** --batch must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	opt := reportOption{
+		Delimiter: *delimiter,
+		Depth:     *depth,
+		Bounds:    bounds,
+		Batch:     *batch,
+	}
+
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	ctx := context.Background()
+	var lineCount int64
+	if len(files) > 0 {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	} else {
+		go func() {
+			defer close(chLine)
+			client := conn.NewClient()
+			defer client.Close()
+
+			var err error
+			if *mode == "random" {
+				lineCount, err = redisutil.SampleRandomKeys(ctx, client, *samples, chLine)
+			} else {
+				lineCount, _, err = redisutil.SampleScanKeys(ctx, client,
+					redisutil.ScanArgs{Match: *match, Count: *scanCount}, *scanRate, *samples, chLine)
+			}
+			if err != nil {
+				log.Fatalf("*** Sampling: %v", err)
+			}
+		}()
+	}
+
+	chResult := make(chan redisutil.Result, *worker)
+	chReport := make(chan report, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- ttlReport(ctx, index, conn, chLine, chReport, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	totalReport := report{}
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+		totalReport.merge(<-chReport, bounds)
+	}
+
+	if *format == "json" {
+		writeJSON(totalReport, bounds)
+	} else {
+		writeText(totalReport, bounds)
+	}
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+}
+
+// sortedPrefixes 件数の多い順に並べたプレフィックス
+func sortedPrefixes(r report) []string {
+	prefixes := make([]string, 0, len(r))
+	for prefix := range r {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := r[prefixes[i]], r[prefixes[j]]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	return prefixes
+}
+
+func writeText(r report, bounds []time.Duration) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	fmt.Fprint(w, "prefix\ttotal\tnever\t")
+	for _, l := range redisutil.NewDurationHistogram(bounds).Labels() {
+		fmt.Fprint(w, l+"\t")
+	}
+	fmt.Fprintln(w)
+
+	total := &prefixStat{Hist: redisutil.NewDurationHistogram(bounds)}
+	row := func(prefix string, st *prefixStat) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t", prefix, st.Total, st.NoExpire)
+		for _, c := range st.Hist.Counts {
+			fmt.Fprint(w, strconv.FormatUint(c, 10)+"\t")
+		}
+		fmt.Fprintln(w)
+	}
+	for _, prefix := range sortedPrefixes(r) {
+		st := r[prefix]
+		row(prefix, st)
+		total.Total += st.Total
+		total.NoExpire += st.NoExpire
+		total.Hist.Merge(st.Hist)
+	}
+	row("(total)", total)
+}
+
+type jsonPrefix struct {
+	Prefix   string   `json:"prefix"`
+	Total    uint64   `json:"total"`
+	NoExpire uint64   `json:"no_expire"`
+	Counts   []uint64 `json:"counts"`
+}
+
+type jsonReport struct {
+	Buckets  []string     `json:"buckets"`
+	Prefixes []jsonPrefix `json:"prefixes"`
+}
+
+func writeJSON(r report, bounds []time.Duration) {
+	out := jsonReport{
+		Buckets:  redisutil.NewDurationHistogram(bounds).Labels(),
+		Prefixes: make([]jsonPrefix, 0, len(r)),
+	}
+	for _, prefix := range sortedPrefixes(r) {
+		st := r[prefix]
+		out.Prefixes = append(out.Prefixes, jsonPrefix{
+			Prefix:   prefix,
+			Total:    st.Total,
+			NoExpire: st.NoExpire,
+			Counts:   st.Hist.Counts,
+		})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		panic(err)
+	}
+}
+
+// ttlReport キーをBatch件ずつpipelineでPTTLして、集計結果をchReportに送る
+func ttlReport(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chReport chan<- report, opt reportOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+	rep := report{}
+
+	keys := make([]string, 0, opt.Batch)
+	flush := func() {
+		if len(keys) == 0 {
+			return
+		}
+		pipe := client.Pipeline()
+		cmds := make([]*redis.DurationCmd, 0, len(keys))
+		for _, key := range keys {
+			cmds = append(cmds, pipe.PTTL(ctx, key))
+		}
+		// 個別のエラーは各cmdで見る
+		_, _ = pipe.Exec(ctx)
+
+		for j, cmd := range cmds {
+			d, err := cmd.Result()
+			if err != nil {
+				result.AddError(err.Error())
+				continue
+			}
+			// go-redisのPTTLは-1/-2を単位なしのDurationにする
+			if d == -2 {
+				result.AddError("Key does not exist")
+				continue
+			}
+
+			st := rep.stat(redisutil.KeyPrefix(keys[j], opt.Delimiter, opt.Depth), opt.Bounds)
+			st.Total++
+			if d < 0 {
+				st.NoExpire++
+			} else {
+				st.Hist.Add(d)
+			}
+		}
+		keys = keys[:0]
+	}
+
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]ttl-report: %d\n", i, lc)
+		}
+
+		keys = append(keys, key)
+		if len(keys) >= opt.Batch {
+			flush()
+		}
+	}
+	flush()
+
+	fmt.Fprintf(os.Stderr, "[%02d]ttl-report: %d, Elapsed: %s\n", i, lc, time.Since(from))
+
+	result.Lines = lc
+	chReport <- rep
+
+	return result
+}
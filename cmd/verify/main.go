@@ -0,0 +1,276 @@
+package main
+
+/*
+ * 2つのRedisからキーをサンプリングし、値のダイジェストをサーバ側で計算して比較する。
+ * 全件diffは重いので、サンプル数から不一致率の信頼上限を求めて一致の程度を評価する。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+// キーの型ごとに内容を正規化してsha1を求める
+// set/hashは順序が保証されないのでソートしてから連結する
+const contentDigestScript = `
+local t = redis.call('TYPE', KEYS[1])['ok']
+if t == 'none' then
+	return ''
+end
+local parts
+if t == 'string' then
+	parts = {redis.call('GET', KEYS[1])}
+elseif t == 'list' then
+	parts = redis.call('LRANGE', KEYS[1], 0, -1)
+elseif t == 'set' then
+	parts = redis.call('SMEMBERS', KEYS[1])
+	table.sort(parts)
+elseif t == 'zset' then
+	parts = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+elseif t == 'hash' then
+	local kv = redis.call('HGETALL', KEYS[1])
+	parts = {}
+	for i = 1, #kv, 2 do
+		parts[#parts + 1] = #kv[i] .. ':' .. kv[i] .. kv[i + 1]
+	end
+	table.sort(parts)
+else
+	parts = {redis.call('DUMP', KEYS[1])}
+end
+for i = 1, #parts do
+	parts[i] = #parts[i] .. ':' .. parts[i]
+end
+return t .. ':' .. redis.sha1hex(table.concat(parts))
+`
+
+// DUMPのシリアライズ結果はエンコーディングやRDBバージョンに依存するので、
+// 同一バージョン・同一設定間の比較でのみ使う
+const dumpDigestScript = `
+local v = redis.call('DUMP', KEYS[1])
+if not v then
+	return ''
+end
+return redis.sha1hex(v)
+`
+
+var digestScripts = map[string]*redis.Script{
+	"content": redis.NewScript(contentDigestScript),
+	"dump":    redis.NewScript(dumpDigestScript),
+}
+
+type verifyResult struct {
+	redisutil.Result
+	Compared   uint64
+	Mismatched uint64
+}
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	mode := flag.String("mode", "random", "How to sample keys when no files specified {random|scan}")
+	samples := flag.Uint64("samples", 10000, "Number of keys to sample(0=all scanned keys in scan mode)")
+	scanRate := flag.Float64("scan-rate", 0.01, "Fraction of scanned keys to be sampled in scan mode")
+	scanCount := flag.Int64("scan-count", 1000, "Scan count at once")
+	match := flag.String("match", "", "Pattern of keys to scan in scan mode")
+	digest := flag.String("digest", "content", "{content|dump}")
+	confidence := flag.Float64("confidence", 0.95, "Confidence level for the upper bound of mismatch rate")
+	tolerance := flag.Float64("tolerance", 0.001, "Acceptable mismatch rate")
+	out := flag.String("out", "mismatch-", "path/to/prefix-of-file-")
+	outSplit := flag.Uint("out-split", 1, "Number of output files")
+	compress := flag.String("compress", "none", "{gzip|none=without compression}")
+	worker := flag.Uint("worker", 32, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var src, dst redisutil.Connection
+	src.RegisterPrefixedFlags(flag.CommandLine, "src-", "Source ")
+	src.RegisterReplicaFlag(flag.CommandLine)
+	dst.RegisterPrefixedFlags(flag.CommandLine, "dst-", "Destination ")
+	dst.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(src.Nodes) == 0 || len(dst.Nodes) == 0 {
+		log.Fatalf("*** --src-node and --dst-node must be specified")
+	}
+
+	for _, c := range []*redisutil.Connection{&src, &dst} {
+		if err := c.Validate(); err != nil {
+			log.Fatalf("*** %v", err)
+		}
+	}
+
+	script, ok := digestScripts[*digest]
+	if !ok {
+		log.Fatalf("*** Unknown --digest: %s", *digest)
+	}
+
+	if len(files) == 0 {
+		switch *mode {
+		case "random":
+			if *samples == 0 {
+				log.Fatalf("*** --samples must be >= 1 in random mode")
+			}
+		case "scan":
+			if *scanRate <= 0 || *scanRate > 1 {
+				log.Fatalf("*** --scan-rate must be in (0, 1]")
+			}
+		default:
+			log.Fatalf("*** Unknown --mode: %s", *mode)
+		}
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	chOut := make(chan string, *outSplit)
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	wgOut := redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+
+	ctx := context.Background()
+	var lineCount int64
+	if len(files) > 0 {
+		chFile := make(chan uint64)
+		for i, file := range files {
+			// ファイルを分割並列入力して、入力行をチャンネルに投げる
+			sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+			index := i
+			fn := file
+			go func() {
+				lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+				chFile <- lc
+			}()
+		}
+
+		// ファイル入力が全部終わったら、入力行chを閉じる
+		go func() {
+			for i := 0; i < len(files); i++ {
+				lc := <-chFile
+				lineCount = lineCount + int64(lc)
+			}
+			close(chLine)
+		}()
+	} else {
+		go func() {
+			defer close(chLine)
+			client := src.NewClient()
+			defer client.Close()
+
+			var err error
+			if *mode == "random" {
+				lineCount, err = redisutil.SampleRandomKeys(ctx, client, *samples, chLine)
+			} else {
+				lineCount, _, err = redisutil.SampleScanKeys(ctx, client,
+					redisutil.ScanArgs{Match: *match, Count: *scanCount}, *scanRate, *samples, chLine)
+			}
+			if err != nil {
+				log.Fatalf("*** Sampling: %v", err)
+			}
+		}()
+	}
+
+	chResult := make(chan verifyResult, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- verify(ctx, index, src, dst, script, chLine, chOut)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	var compared, mismatched uint64
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result.Result)
+		compared += result.Compared
+		mismatched += result.Mismatched
+	}
+
+	close(chOut)
+	wgOut.Wait()
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+	fmt.Fprintf(os.Stderr, "Compared: %d, Mismatched: %d\n", compared, mismatched)
+	fmt.Fprintf(os.Stderr, "Mismatch rate <= %.6f%% with %.2f%% confidence\n",
+		redisutil.MismatchUpperBound(compared, mismatched, *confidence)*100, *confidence*100)
+	fmt.Fprintf(os.Stderr, "Confidence that mismatch rate < %.6f%%: %.4f%%\n",
+		*tolerance*100, redisutil.ConsistencyConfidence(compared, mismatched, *tolerance)*100)
+}
+
+func verify(ctx context.Context, i uint, srcConn redisutil.Connection, dstConn redisutil.Connection, script *redis.Script,
+	chLine <-chan string, chOut chan<- string) verifyResult {
+	src := srcConn.NewClient()
+	defer src.Close()
+	dst := dstConn.NewClient()
+	defer dst.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := verifyResult{Result: redisutil.NewResult()}
+
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]verify: %d\n", i, lc)
+		}
+
+		srcDigest, err := script.Run(ctx, src, []string{key}).Text()
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		} else if srcDigest == "" {
+			// サンプリング後に消えた/期限切れになったキーは比較対象にしない
+			result.AddError("Key does not exist on src")
+			continue
+		}
+
+		dstDigest, err := script.Run(ctx, dst, []string{key}).Text()
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+
+		result.Compared++
+		if dstDigest == "" {
+			result.Mismatched++
+			chOut <- key + "\tmissing"
+		} else if srcDigest != dstDigest {
+			result.Mismatched++
+			chOut <- key + "\tdiffer"
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "[%02d]verify: %d, Elapsed: %s\n", i, lc, time.Since(from))
+
+	result.Lines = lc
+
+	return result
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	worker := flag.Uint("worker", 32, "Number of worker goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	maxLen := flag.Int64("maxlen", 0, "Trim the stream to this length on each XADD(XADD MAXLEN)")
+	minID := flag.String("minid", "", "Evict entries with IDs lower than this on each XADD(XADD MINID)")
+	approx := flag.Bool("approx", false, "Trim approximately with ~ for efficiency")
+	noMkStream := flag.Bool("nomkstream", false, "Do not create the stream if it does not exist(XADD NOMKSTREAM)")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	if *maxLen > 0 && *minID != "" {
+		log.Fatalf("*** --maxlen and --minid are mutually exclusive")
+	}
+
+	tmpl := redis.XAddArgs{
+		NoMkStream: *noMkStream,
+		MaxLen:     *maxLen,
+		MinID:      *minID,
+		Approx:     *approx,
+	}
+
+	chLine := make(chan string, *worker)
+	from := time.Now()
+
+	chFile := make(chan uint64)
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	var lineCount int64
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		index := i
+		go func() {
+			chResult <- xadd(ctx, index, conn, chLine, tmpl)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors, totalResult.Counts)
+}
+
+// xadd 1行を1エントリとして追加する
+// IDを指定して追加する場合、同じストリームの行はIDの昇順に並んでいて、1つのworkerで処理される必要がある
+func xadd(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, tmpl redis.XAddArgs) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for line := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]xadd: %d\n", i, lc)
+		}
+
+		// {key}    {id}	{json}
+		tokens := strings.SplitN(line, "\t", 3)
+		if len(tokens) != 3 {
+			result.AddError("Number of tokens != 3")
+			continue
+		}
+
+		// エントリ内のフィールドの順序を保つためmapにしない
+		values, err := redisutil.ParseOrderedJSONObject(tokens[2])
+		if err != nil {
+			result.AddError("Invalid json")
+			continue
+		}
+		if len(values) == 0 {
+			result.AddError("No fields")
+			continue
+		}
+
+		args := tmpl
+		args.Stream = tokens[0]
+		args.ID = tokens[1]
+		args.Values = values
+		_, err = client.XAdd(ctx, &args).Result()
+		if err == redis.Nil {
+			// NOMKSTREAMでストリームがなかった
+			result.AddCount("not added", 1)
+			continue
+		} else if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+		result.AddCount("added", 1)
+	}
+
+	fmt.Fprintf(os.Stderr, "[%02d]xadd: %d, Elapsed: %s\n", i, lc, time.Since(from))
+
+	result.Lines = lc
+
+	return result
+}
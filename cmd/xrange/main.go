@@ -0,0 +1,278 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	start := flag.String("start", "-", "Start ID of range")
+	end := flag.String("end", "+", "End ID of range")
+	count := flag.Int64("count", 1000, "Number of entries to fetch by one XRANGE")
+	groupsOut := flag.String("groups-out", "", "path/to/prefix-of-file- for consumer group info(empty=not output)")
+	out := flag.String("out", "out-", "path/to/prefix-of-file-")
+	outSplit := flag.Uint("out-split", 5, "Number of output files")
+	compress := flag.String("compress", "none", "{gzip|none=without compression}")
+	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	conn.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	if *count <= 0 {
+		log.Fatalf("*** --count must be >= 1")
+	}
+
+	opt := xrangeOption{
+		Start:  *start,
+		End:    *end,
+		Count:  *count,
+		Groups: *groupsOut != "",
+	}
+
+	chOut := make(chan string, *outSplit)
+	chLine := make(chan string, *worker)
+	chFile := make(chan uint64)
+	from := time.Now()
+
+	wgOut := redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+
+	var chGroup chan string
+	var wgGroup *sync.WaitGroup
+	if opt.Groups {
+		chGroup = make(chan string, *outSplit)
+		wgGroup = redisutil.StartWriters(*outSplit, *groupsOut, *compress, chGroup)
+	}
+
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	var lineCount int64
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		// 入力行を受け取ってredisからxrangeする
+		index := i
+		go func() {
+			chResult <- xrange(ctx, index, conn, chLine, chOut, chGroup, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	close(chOut)
+	wgOut.Wait()
+
+	if chGroup != nil {
+		close(chGroup)
+		wgGroup.Wait()
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors, totalResult.Counts)
+}
+
+type xrangeOption struct {
+	Start  string
+	End    string
+	Count  int64
+	Groups bool
+}
+
+type groupInfo struct {
+	Name            string `json:"name"`
+	Consumers       int64  `json:"consumers"`
+	Pending         int64  `json:"pending"`
+	LastDeliveredID string `json:"last-delivered-id"`
+}
+
+// nextID 指定IDの直後のIDを返す。Redis 6.2未満は排他的な範囲指定"("が使えないのでseqを進める
+func nextID(id string) string {
+	p := strings.LastIndex(id, "-")
+	if p < 0 {
+		return id + "-1"
+	}
+	seq, err := strconv.ParseUint(id[p+1:], 10, 64)
+	if err != nil {
+		return id
+	}
+	return id[:p+1] + strconv.FormatUint(seq+1, 10)
+}
+
+// parseEntries XRANGEの応答をフィールドの順序を保ったまま取り出す
+// go-redisのXRange()はフィールドをmapにしてしまうのでDoで直接受け取る
+func parseEntries(reply interface{}) ([]string, [][]string, error) {
+	entries, ok := reply.([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected reply: %T", reply)
+	}
+
+	ids := make([]string, 0, len(entries))
+	fields := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) != 2 {
+			return nil, nil, fmt.Errorf("unexpected entry: %v", e)
+		}
+		id, _ := entry[0].(string)
+		raw, _ := entry[1].([]interface{})
+		kv := make([]string, 0, len(raw))
+		for _, v := range raw {
+			s, _ := v.(string)
+			kv = append(kv, s)
+		}
+		ids = append(ids, id)
+		fields = append(fields, kv)
+	}
+	return ids, fields, nil
+}
+
+// xrange xaddの入力と同じ{key}\t{id}\t{json}の形式で、IDの昇順にCount件ずつページングして出力する
+// 1つのキーの行は順番にchOutへ送るので、--out-split=1なら出力ファイル上でもIDの順序が保たれる
+func xrange(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, chGroup chan<- string, opt xrangeOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]xrange: %d\n", i, lc)
+		}
+
+		start := opt.Start
+		for {
+			reply, err := client.Do(ctx, "xrange", key, start, opt.End, "count", opt.Count).Result()
+			if err != nil {
+				result.AddError(err.Error())
+				break
+			}
+			ids, fields, err := parseEntries(reply)
+			if err != nil {
+				result.AddError(err.Error())
+				break
+			}
+
+			for j, id := range ids {
+				chOut <- key + "\t" + id + "\t" + redisutil.FormatOrderedJSONObject(fields[j])
+			}
+			result.AddCount("entries", uint64(len(ids)))
+
+			if int64(len(ids)) < opt.Count {
+				break
+			}
+			start = nextID(ids[len(ids)-1])
+		}
+
+		if !opt.Groups {
+			continue
+		}
+
+		groups, err := client.XInfoGroups(ctx, key).Result()
+		if err != nil {
+			if err != redis.Nil {
+				result.AddError(err.Error())
+			}
+			continue
+		}
+		for _, g := range groups {
+			b, err := json.Marshal(groupInfo{
+				Name:            g.Name,
+				Consumers:       g.Consumers,
+				Pending:         g.Pending,
+				LastDeliveredID: g.LastDeliveredID,
+			})
+			if err != nil {
+				panic(err)
+			}
+			chGroup <- key + "\t" + string(b)
+		}
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]xrange: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
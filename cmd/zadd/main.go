@@ -26,9 +26,16 @@ func main() {
 	randomKeys := flag.Uint("random", 0, "Number of pairs that consist of member and score to generate")
 	randomPrefix := flag.String("random-prefix", "rand-", "Prefix of random generated member")
 	key := flag.String("key", "", "Key of ZSET")
-	var nodes redisutil.StrSlice
-	flag.Var(&nodes, "node", "Redis server host and port(ex. 127.0.0.1:6379)")
-	flag.Parse()
+	nx := flag.Bool("nx", false, "Only add new members(ZADD NX)")
+	xx := flag.Bool("xx", false, "Only update existing members(ZADD XX)")
+	gt := flag.Bool("gt", false, "Update existing members only if new score is greater. New members are still added(ZADD GT)")
+	lt := flag.Bool("lt", false, "Update existing members only if new score is less. New members are still added(ZADD LT)")
+	incr := flag.Bool("incr", false, "Increment score of each member by the score instead of setting it(ZINCRBY)")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
 	files := flag.Args()
 
 	if *showVersion {
@@ -46,8 +53,12 @@ func main() {
 		}
 	}
 
-	if len(nodes) == 0 {
-		nodes = []string{"127.0.0.1:6379"}
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
 	}
 
 	if *inSplit <= 0 {
@@ -58,6 +69,22 @@ func main() {
 		log.Fatalf("*** --worker must be >= 1")
 	}
 
+	if *nx && (*xx || *gt || *lt) {
+		log.Fatalf("*** --nx can not be used with --xx, --gt or --lt")
+	}
+
+	if *gt && *lt {
+		log.Fatalf("*** --gt and --lt are mutually exclusive")
+	}
+
+	opt := zaddOption{
+		NX:   *nx,
+		XX:   *xx,
+		GT:   *gt,
+		LT:   *lt,
+		Incr: *incr,
+	}
+
 	chLine := make(chan string, *worker)
 	from := time.Now()
 
@@ -100,7 +127,7 @@ func main() {
 	for i := uint(0); i < *worker; i++ {
 		index := i
 		go func() {
-			chResult <- zadd(ctx, index, nodes, chLine)
+			chResult <- zadd(ctx, index, conn, chLine, opt)
 		}()
 	}
 
@@ -111,12 +138,30 @@ func main() {
 		totalResult = totalResult.Combine(result)
 	}
 
-	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
-		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v, Counts: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, time.Since(from), totalResult.Errors, totalResult.Counts)
+}
+
+type zaddOption struct {
+	NX   bool
+	XX   bool
+	GT   bool
+	LT   bool
+	Incr bool
+}
+
+func (o zaddOption) args(members []redis.Z) redis.ZAddArgs {
+	return redis.ZAddArgs{
+		NX:      o.NX,
+		XX:      o.XX,
+		GT:      o.GT,
+		LT:      o.LT,
+		Members: members,
+	}
 }
 
-func zadd(ctx context.Context, i uint, nodes []string, chLine <-chan string) redisutil.Result {
-	client := redisutil.NewRedisClient(nodes)
+func zadd(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, opt zaddOption) redisutil.Result {
+	client := conn.NewClient()
 	defer client.Close()
 
 	var lc uint64
@@ -124,7 +169,7 @@ func zadd(ctx context.Context, i uint, nodes []string, chLine <-chan string) red
 
 	result := redisutil.NewResult()
 
-	members := make([]*redis.Z, 0, 1024)
+	members := make([]redis.Z, 0, 1024)
 	for line := range chLine {
 		lc++
 		if lc%100000 == 0 {
@@ -139,27 +184,47 @@ func zadd(ctx context.Context, i uint, nodes []string, chLine <-chan string) red
 			continue
 		}
 
-		if cap(members) < tokenCount-1 {
-			members = make([]*redis.Z, 0, tokenCount-1)
-		}
-
+		// スコアが1つでも不正なら、その行は全て捨てる
 		members = members[:0]
+		var err error
 		for i := 1; i < tokenCount; i += 2 {
-			score, err := strconv.ParseFloat(tokens[i], 64)
+			var score float64
+			score, err = strconv.ParseFloat(tokens[i], 64)
 			if err != nil {
-				result.AddError(err.Error())
-				continue
+				break
 			}
-			members = append(members, &redis.Z{
+			members = append(members, redis.Z{
 				Score:  score,
 				Member: tokens[i+1],
 			})
 		}
-		_, err := client.ZAdd(ctx, tokens[0], members...).Result()
 		if err != nil {
 			result.AddError(err.Error())
 			continue
 		}
+
+		if opt.Incr {
+			zincr(ctx, client, tokens[0], members, opt, &result)
+			continue
+		}
+
+		// CHの戻り値は追加+更新の件数なので、前後のZCARDの差から追加件数を求めて内訳を出す
+		args := opt.args(members)
+		args.Ch = true
+		var before, changed, after *redis.IntCmd
+		_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			before = pipe.ZCard(ctx, tokens[0])
+			changed = pipe.ZAddArgs(ctx, tokens[0], args)
+			after = pipe.ZCard(ctx, tokens[0])
+			return nil
+		})
+		if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+		added := after.Val() - before.Val()
+		result.AddCount("added", uint64(added))
+		result.AddCount("updated", uint64(changed.Val()-added))
 	}
 
 	fmt.Fprintf(os.Stderr, "[%02d]zadd: %d, Elapsed: %s\n", i, lc, time.Since(from))
@@ -168,3 +233,27 @@ func zadd(ctx context.Context, i uint, nodes []string, chLine <-chan string) red
 
 	return result
 }
+
+// zincr メンバ毎にZADD INCRを発行する
+// ZADD INCRは1メンバしか受け付けないのでpipelineでまとめて送る。条件がなければZINCRBYと同じ
+func zincr(ctx context.Context, client redis.UniversalClient, key string, members []redis.Z, opt zaddOption, result *redisutil.Result) {
+	pipe := client.Pipeline()
+	cmds := make([]*redis.FloatCmd, 0, len(members))
+	for _, m := range members {
+		cmds = append(cmds, pipe.ZAddArgsIncr(ctx, key, opt.args([]redis.Z{m})))
+	}
+	// 個別のエラーは各cmdで見る
+	_, _ = pipe.Exec(ctx)
+
+	for _, cmd := range cmds {
+		err := cmd.Err()
+		if err == redis.Nil {
+			// NX/XX/GT/LTの条件で更新されなかった
+			result.AddCount("skipped", 1)
+		} else if err != nil {
+			result.AddError(err.Error())
+		} else {
+			result.AddCount("incremented", 1)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+/*
+ * 入力ファイルを並列分割処理するためオフセットの計算が必要になる都合、
+ * 改行コードは「必ずLF」であることを前提としている。
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisutil "github.com/tckz/redis-util"
+)
+
+var version string
+
+func main() {
+
+	showVersion := flag.Bool("version", false, "Show version")
+	chunk := flag.Int64("chunk", 1000, "Number of members to fetch by one ZRANGE, and max number of members per output line")
+	byScore := flag.Bool("by-score", false, "Select members by --min and --max score instead of --start and --stop rank")
+	minScore := flag.String("min", "-inf", "Min score with --by-score(ex. 10, (10, -inf)")
+	maxScore := flag.String("max", "+inf", "Max score with --by-score(ex. 20, (20, +inf)")
+	start := flag.Int64("start", 0, "Start rank")
+	stop := flag.Int64("stop", -1, "Stop rank")
+	rev := flag.Bool("rev", false, "Output members in descending order of score")
+	limit := flag.Int64("limit", 0, "Max number of members per key(0=unlimited)")
+	out := flag.String("out", "out-", "path/to/prefix-of-file-")
+	outSplit := flag.Uint("out-split", 5, "Number of output files")
+	compress := flag.String("compress", "none", "{gzip|none=without compression}")
+	worker := flag.Uint("worker", 32, "Number of receiving goroutines")
+	inSplit := flag.Uint("in-split", 8, "Number of goroutines for reading file")
+	var conn redisutil.Connection
+	conn.RegisterFlags(flag.CommandLine)
+	conn.RegisterReplicaFlag(flag.CommandLine)
+	if err := redisutil.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+	files := flag.Args()
+
+	if *showVersion {
+		fmt.Fprintln(os.Stdout, version)
+		return
+	}
+
+	if len(files) == 0 {
+		log.Fatalf("*** Files to load must be specified")
+	}
+
+	if len(conn.Nodes) == 0 {
+		conn.Nodes = []string{"127.0.0.1:6379"}
+	}
+
+	if err := conn.Validate(); err != nil {
+		log.Fatalf("*** %v", err)
+	}
+
+	if *inSplit <= 0 {
+		log.Fatalf("*** --in-split must be >= 1")
+	}
+
+	if *outSplit <= 0 {
+		log.Fatalf("*** --out-split must be >= 1")
+	}
+
+	if *worker <= 0 {
+		log.Fatalf("*** --worker must be >= 1")
+	}
+
+	if *chunk <= 0 {
+		log.Fatalf("*** --chunk must be >= 1")
+	}
+
+	if *limit < 0 {
+		log.Fatalf("*** --limit must be >= 0")
+	}
+
+	opt := rangeOption{
+		ByScore: *byScore,
+		Min:     *minScore,
+		Max:     *maxScore,
+		Start:   *start,
+		Stop:    *stop,
+		Rev:     *rev,
+		Limit:   *limit,
+		Chunk:   *chunk,
+	}
+
+	chOut := make(chan string, *outSplit)
+	chLine := make(chan string, *worker)
+	chFile := make(chan uint64)
+	from := time.Now()
+
+	wgOut := redisutil.StartWriters(*outSplit, *out, *compress, chOut)
+
+	for i, file := range files {
+		// ファイルを分割並列入力して、入力行をチャンネルに投げる
+		sr := redisutil.SplitReader{MinBlockSize: 1024 * 4}
+		index := i
+		fn := file
+		go func() {
+			lc := sr.LoadFile(uint(index), *inSplit, fn, chLine, 100000)
+			chFile <- lc
+		}()
+	}
+
+	var lineCount int64
+	// ファイル入力が全部終わったら、入力行chを閉じる
+	go func() {
+		for i := 0; i < len(files); i++ {
+			lc := <-chFile
+			lineCount = lineCount + int64(lc)
+		}
+		close(chLine)
+	}()
+
+	ctx := context.Background()
+	chResult := make(chan redisutil.Result, *worker)
+	for i := uint(0); i < *worker; i++ {
+		// 入力行を受け取ってredisからzrangeする
+		index := i
+		go func() {
+			chResult <- zrange(ctx, index, conn, chLine, chOut, opt)
+		}()
+	}
+
+	// 全ての受信goルーチンが終わったら終了
+	totalResult := redisutil.NewResult()
+	for i := uint(0); i < *worker; i++ {
+		result := <-chResult
+		totalResult = totalResult.Combine(result)
+	}
+
+	close(chOut)
+	wgOut.Wait()
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "Lines: %d, Got: %d, Bad: %d, Elapsed: %s, Errors: %v\n",
+		lineCount, totalResult.Lines, totalResult.BadCount, elapsed, totalResult.Errors)
+}
+
+type rangeOption struct {
+	ByScore bool
+	Min     string
+	Max     string
+	Start   int64
+	Stop    int64
+	Rev     bool
+	Limit   int64
+	Chunk   int64
+}
+
+// belowMin minより小さい範囲をZCOUNTの引数として返す。該当範囲がなければokはfalse
+func belowMin(min string) (string, string, bool) {
+	if min == "-inf" {
+		return "", "", false
+	}
+	if strings.HasPrefix(min, "(") {
+		return "-inf", min[1:], true
+	}
+	return "-inf", "(" + min, true
+}
+
+// aboveMax maxより大きい範囲をZCOUNTの引数として返す。該当範囲がなければokはfalse
+func aboveMax(max string) (string, string, bool) {
+	if max == "+inf" || max == "inf" {
+		return "", "", false
+	}
+	if strings.HasPrefix(max, "(") {
+		return max[1:], "+inf", true
+	}
+	return "(" + max, "+inf", true
+}
+
+// rankRange 出力対象の範囲を、指定の順序(rev)における順位の範囲に変換する
+// スコア指定の場合もZCOUNTで順位に直すことで、LIMIT offsetによるページングの
+// O(offset)コストを避けて順位でページングできるようにする
+func rankRange(ctx context.Context, client redis.UniversalClient, key string, opt rangeOption) (int64, int64, error) {
+	card, err := client.ZCard(ctx, key).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if card == 0 {
+		return 0, 0, redis.Nil
+	}
+
+	var begin, end int64
+	if opt.ByScore {
+		count, err := client.ZCount(ctx, key, opt.Min, opt.Max).Result()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var lo, hi string
+		var ok bool
+		if opt.Rev {
+			lo, hi, ok = aboveMax(opt.Max)
+		} else {
+			lo, hi, ok = belowMin(opt.Min)
+		}
+		if ok {
+			begin, err = client.ZCount(ctx, key, lo, hi).Result()
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		end = begin + count - 1
+	} else {
+		begin, end = opt.Start, opt.Stop
+		if begin < 0 {
+			begin += card
+		}
+		if end < 0 {
+			end += card
+		}
+		if begin < 0 {
+			begin = 0
+		}
+		if end >= card {
+			end = card - 1
+		}
+	}
+
+	if opt.Limit > 0 && end-begin+1 > opt.Limit {
+		end = begin + opt.Limit - 1
+	}
+
+	return begin, end, nil
+}
+
+// zrange zaddの入力と同じ{key}\t{score}\t{member}...の形式でChunk件ずつ出力する
+func zrange(ctx context.Context, i uint, conn redisutil.Connection, chLine <-chan string, chOut chan<- string, opt rangeOption) redisutil.Result {
+	client := conn.NewClient()
+	defer client.Close()
+
+	var lc uint64
+	from := time.Now()
+
+	result := redisutil.NewResult()
+
+	tokens := make([]string, 0, opt.Chunk*2+1)
+	for key := range chLine {
+		lc++
+		if lc%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "[%02d]zrange: %d\n", i, lc)
+		}
+
+		begin, end, err := rankRange(ctx, client, key, opt)
+		if err == redis.Nil {
+			result.AddError("Key does not exist")
+			continue
+		} else if err != nil {
+			result.AddError(err.Error())
+			continue
+		}
+
+		for page := begin; page <= end; page += opt.Chunk {
+			pageEnd := page + opt.Chunk - 1
+			if pageEnd > end {
+				pageEnd = end
+			}
+
+			var members []redis.Z
+			if opt.Rev {
+				members, err = client.ZRevRangeWithScores(ctx, key, page, pageEnd).Result()
+			} else {
+				members, err = client.ZRangeWithScores(ctx, key, page, pageEnd).Result()
+			}
+			if err != nil {
+				result.AddError(err.Error())
+				break
+			}
+			if len(members) == 0 {
+				break
+			}
+
+			tokens = append(tokens[:0], key)
+			for _, z := range members {
+				tokens = append(tokens,
+					strconv.FormatFloat(z.Score, 'g', -1, 64),
+					fmt.Sprint(z.Member))
+			}
+			chOut <- strings.Join(tokens, "\t")
+		}
+	}
+
+	elapsed := time.Since(from)
+	fmt.Fprintf(os.Stderr, "[%02d]zrange: %d, Elapsed: %s\n", i, lc, elapsed)
+
+	result.Lines = lc
+
+	return result
+}
@@ -0,0 +1,110 @@
+package redisutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{(\d+)\}`)
+
+type templatePart struct {
+	literal string
+	// 0なら置換なし、1以上ならその番号の列で置換する
+	column int
+}
+
+// CommandTemplate 入力行の列を埋め込んでコマンドを組み立てるテンプレート
+// ex. "HINCRBY {1} views {2}"の{n}をタブ区切りのn番目(1始まり)の列で置換する
+type CommandTemplate struct {
+	args [][]templatePart
+	// テンプレートが参照する最大の列番号
+	MaxColumn int
+}
+
+// ParseCommandTemplate 空白区切りのテンプレートを解釈する
+func ParseCommandTemplate(s string) (*CommandTemplate, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty command template")
+	}
+
+	t := &CommandTemplate{}
+	for _, f := range fields {
+		var parts []templatePart
+		pos := 0
+		for _, m := range placeholderPattern.FindAllStringSubmatchIndex(f, -1) {
+			if m[0] > pos {
+				parts = append(parts, templatePart{literal: f[pos:m[0]]})
+			}
+			n, err := strconv.Atoi(f[m[2]:m[3]])
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid placeholder: %s", f[m[0]:m[1]])
+			}
+			if n > t.MaxColumn {
+				t.MaxColumn = n
+			}
+			parts = append(parts, templatePart{column: n})
+			pos = m[1]
+		}
+		if pos < len(f) {
+			parts = append(parts, templatePart{literal: f[pos:]})
+		}
+		t.args = append(t.args, parts)
+	}
+	return t, nil
+}
+
+// Expand 列を埋め込んだコマンドの引数を返す
+func (t *CommandTemplate) Expand(columns []string) ([]interface{}, error) {
+	if len(columns) < t.MaxColumn {
+		return nil, fmt.Errorf("Number of tokens = %d < %d", len(columns), t.MaxColumn)
+	}
+
+	ret := make([]interface{}, 0, len(t.args))
+	for _, parts := range t.args {
+		if len(parts) == 1 {
+			// 列そのものの場合は連結の手間を省く
+			if parts[0].column > 0 {
+				ret = append(ret, columns[parts[0].column-1])
+			} else {
+				ret = append(ret, parts[0].literal)
+			}
+			continue
+		}
+
+		b := &strings.Builder{}
+		for _, p := range parts {
+			if p.column > 0 {
+				b.WriteString(columns[p.column-1])
+			} else {
+				b.WriteString(p.literal)
+			}
+		}
+		ret = append(ret, b.String())
+	}
+	return ret, nil
+}
+
+// FormatReply コマンドの応答を出力用の文字列にする
+// 文字列や数値はそのまま、配列はJSONの配列にする
+func FormatReply(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case []interface{}:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Sprint(t)
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(t)
+	}
+}
@@ -0,0 +1,53 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpl, err := ParseCommandTemplate("HINCRBY {1} views:{3}:x {2}")
+	assert.NoError(err)
+	assert.Equal(3, tmpl.MaxColumn)
+
+	args, err := tmpl.Expand([]string{"k1", "10", "2021"})
+	assert.NoError(err)
+	assert.Equal([]interface{}{"HINCRBY", "k1", "views:2021:x", "10"}, args)
+
+	_, err = tmpl.Expand([]string{"k1", "10"})
+	assert.Error(err)
+}
+
+func TestCommandTemplateNoPlaceholder(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpl, err := ParseCommandTemplate("  PING  ")
+	assert.NoError(err)
+	assert.Equal(0, tmpl.MaxColumn)
+
+	args, err := tmpl.Expand(nil)
+	assert.NoError(err)
+	assert.Equal([]interface{}{"PING"}, args)
+}
+
+func TestParseCommandTemplateError(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ParseCommandTemplate("")
+	assert.Error(err)
+
+	_, err = ParseCommandTemplate("GET {0}")
+	assert.Error(err)
+}
+
+func TestFormatReply(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", FormatReply(nil))
+	assert.Equal("OK", FormatReply("OK"))
+	assert.Equal("12", FormatReply(int64(12)))
+	assert.Equal(`["a",1,null]`, FormatReply([]interface{}{"a", int64(1), nil}))
+}
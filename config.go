@@ -0,0 +1,207 @@
+package redisutil
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix フラグの値を上書きする環境変数のプレフィックス
+const EnvPrefix = "REDIS_UTIL_"
+
+// DefaultConfigFile --configも環境変数もなければ、ホームディレクトリのこのファイルがあれば読む
+const DefaultConfigFile = ".redis-util.yaml"
+
+// overridableFlags 環境変数と設定ファイルで値を入れられるフラグ
+// どのコマンドにも共通の接続先と入出力の設定に限り、--match等の操作の対象を決めるものは含めない
+var overridableFlags = map[string]bool{
+	"worker":    true,
+	"in-split":  true,
+	"out":       true,
+	"out-split": true,
+	"compress":  true,
+}
+
+// connectionFlags 接続先のフラグ。接続先を2つ取るコマンドのsrc-, dst-付きのものも上書きできる
+var connectionFlags = []string{"node", "node-file", "db", "master-name", "sentinel-password"}
+
+// flagGroup 同じ値を入れるフラグをまとめた名前を返す
+// --node-fileは--nodeに追加するので、どちらかを指定したら両方とも指定したものとして扱う
+func flagGroup(name string) string {
+	if strings.HasSuffix(name, "node-file") {
+		return strings.TrimSuffix(name, "-file")
+	}
+	return name
+}
+
+func init() {
+	for _, name := range connectionFlags {
+		for _, prefix := range []string{"", "src-", "dst-"} {
+			overridableFlags[prefix+name] = true
+		}
+	}
+}
+
+// configFile 設定ファイルの形式。キーはフラグ名で、どのコマンドにも共通の1つのファイルに書く
+//
+//	default:
+//	  worker: 64
+//	profiles:
+//	  prod-cache:
+//	    node:
+//	      - redis://:pass@10.0.0.1:6379
+//	    compress: gzip
+type configFile struct {
+	// 全プロファイルに共通の値
+	Default  map[string]interface{}            `yaml:"default"`
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// EnvName フラグ名に対応する環境変数名(ex. sentinel-password -> REDIS_UTIL_SENTINEL_PASSWORD)
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ParseFlags --configと--profileを加えてargsを解析し、コマンドラインで指定しなかったフラグに
+// 環境変数、設定ファイルのプロファイル、defaultの順に見つかった値を入れる
+// 上書きできるのはoverridableFlagsのみで、設定ファイルにそれ以外を書くとエラー。そのコマンドにないフラグの値は無視する
+// 環境変数や設定ファイルから入れた値もfs.Visitで指定したものとして扱える
+func ParseFlags(fs *flag.FlagSet, args []string) error {
+	config := fs.String("config", "", "Config file in YAML(default: $"+EnvName("config")+" or ~/"+DefaultConfigFile+" if exists)")
+	profile := fs.String("profile", "", "Profile name in the config file(default: $"+EnvName("profile")+")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// 指定済みのフラグはflagGroupの名前で持つ
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[flagGroup(f.Name)] = true
+	})
+
+	// 同じ環境変数の中ではNODEとNODE_FILEを両方使えるように、環境変数で入れたものは後でsetに加える
+	setByEnv := map[string]bool{}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[flagGroup(f.Name)] {
+			return
+		}
+		// --configと--profileは環境変数でのみ指定できる
+		if !overridableFlags[f.Name] && f.Name != "config" && f.Name != "profile" {
+			return
+		}
+		v, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok {
+			return
+		}
+		if _, isSlice := f.Value.(*StrSlice); isSlice {
+			// 複数指定できるフラグはカンマ区切り
+			for _, e := range strings.Split(v, ",") {
+				if err = fs.Set(f.Name, strings.TrimSpace(e)); err != nil {
+					break
+				}
+			}
+		} else {
+			err = fs.Set(f.Name, v)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %v", EnvName(f.Name), err)
+			return
+		}
+		setByEnv[flagGroup(f.Name)] = true
+	})
+	if err != nil {
+		return err
+	}
+	for name := range setByEnv {
+		set[name] = true
+	}
+
+	path := *config
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(home, DefaultConfigFile)); err == nil {
+				path = filepath.Join(home, DefaultConfigFile)
+			}
+		}
+	}
+	if path == "" {
+		if *profile != "" {
+			return fmt.Errorf("--profile requires a config file")
+		}
+		return nil
+	}
+
+	values, err := LoadConfigProfile(path, *profile)
+	if err != nil {
+		return err
+	}
+	for name, v := range values {
+		if !overridableFlags[name] {
+			return fmt.Errorf("%s: %s: not allowed in the config file", path, name)
+		}
+		f := fs.Lookup(name)
+		if f == nil || set[flagGroup(name)] {
+			continue
+		}
+		if err := setFlagValue(fs, f, v); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// LoadConfigProfile 設定ファイルのdefaultにprofileの値を重ねたものを返す。profileが空ならdefaultのみ
+func LoadConfigProfile(path string, profile string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cf configFile
+	if err := yaml.Unmarshal(b, &cf); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := map[string]interface{}{}
+	for k, v := range cf.Default {
+		values[k] = v
+	}
+	if profile != "" {
+		p, ok := cf.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("%s: profile not found: %s", path, profile)
+		}
+		for k, v := range p {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// setFlagValue 設定ファイルの値をフラグに入れる。リストは複数指定できるフラグにだけ書ける
+func setFlagValue(fs *flag.FlagSet, f *flag.Flag, v interface{}) error {
+	switch tv := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		if _, isSlice := f.Value.(*StrSlice); !isSlice {
+			return fmt.Errorf("list is not allowed")
+		}
+		for _, e := range tv {
+			if err := fs.Set(f.Name, fmt.Sprint(e)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		return fmt.Errorf("map is not allowed")
+	default:
+		return fs.Set(f.Name, fmt.Sprint(tv))
+	}
+}
@@ -0,0 +1,196 @@
+package redisutil
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+default:
+  worker: 64
+  compress: gzip
+  # このコマンドにはないフラグ
+  dst-node: 10.0.0.9:6379
+profiles:
+  prod-cache:
+    node:
+      - redis://:pass@10.0.0.1:6379
+      - redis://:pass@10.0.0.2:6379
+    worker: 128
+    out-split: 10
+  bad:
+    worker: [1, 2]
+`
+
+func writeTestConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type testFlags struct {
+	conn     Connection
+	worker   *uint
+	outSplit *uint
+	compress *string
+}
+
+func newTestFlagSet() (*flag.FlagSet, *testFlags) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	tf := &testFlags{}
+	tf.worker = fs.Uint("worker", 32, "")
+	tf.outSplit = fs.Uint("out-split", 5, "")
+	tf.compress = fs.String("compress", "none", "")
+	tf.conn.RegisterFlags(fs)
+	return fs, tf
+}
+
+func TestEnvName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("REDIS_UTIL_SENTINEL_PASSWORD", EnvName("sentinel-password"))
+	assert.Equal("REDIS_UTIL_NODE", EnvName("node"))
+}
+
+func TestParseFlagsProfile(t *testing.T) {
+	assert := assert.New(t)
+	path := writeTestConfig(t)
+
+	fs, tf := newTestFlagSet()
+	err := ParseFlags(fs, []string{"--config", path, "--profile", "prod-cache", "--out-split", "3"})
+	assert.NoError(err)
+
+	assert.Equal(StrSlice{"redis://:pass@10.0.0.1:6379", "redis://:pass@10.0.0.2:6379"}, tf.conn.Nodes)
+	// プロファイルがdefaultより優先
+	assert.Equal(uint(128), *tf.worker)
+	assert.Equal("gzip", *tf.compress)
+	// コマンドラインが設定ファイルより優先
+	assert.Equal(uint(3), *tf.outSplit)
+}
+
+func TestParseFlagsEnv(t *testing.T) {
+	assert := assert.New(t)
+	path := writeTestConfig(t)
+
+	os.Setenv(EnvName("config"), path)
+	os.Setenv(EnvName("profile"), "prod-cache")
+	os.Setenv(EnvName("node"), "10.0.0.3:6379, 10.0.0.4:6379")
+	os.Setenv(EnvName("worker"), "16")
+	defer func() {
+		for _, name := range []string{"config", "profile", "node", "worker"} {
+			os.Unsetenv(EnvName(name))
+		}
+	}()
+
+	fs, tf := newTestFlagSet()
+	err := ParseFlags(fs, []string{"--worker", "8"})
+	assert.NoError(err)
+
+	// 環境変数が設定ファイルより優先
+	assert.Equal(StrSlice{"10.0.0.3:6379", "10.0.0.4:6379"}, tf.conn.Nodes)
+	// コマンドラインが環境変数より優先
+	assert.Equal(uint(8), *tf.worker)
+	assert.Equal(uint(10), *tf.outSplit)
+}
+
+func TestParseFlagsError(t *testing.T) {
+	assert := assert.New(t)
+	path := writeTestConfig(t)
+
+	fs, _ := newTestFlagSet()
+	assert.Error(ParseFlags(fs, []string{"--config", path, "--profile", "not-exist"}))
+
+	fs, _ = newTestFlagSet()
+	assert.Error(ParseFlags(fs, []string{"--config", path, "--profile", "bad"}))
+
+	fs, _ = newTestFlagSet()
+	assert.Error(ParseFlags(fs, []string{"--config", filepath.Join(t.TempDir(), "not-exist.yaml")}))
+
+	// 操作の対象を決めるフラグは設定ファイルに書けない
+	p := filepath.Join(t.TempDir(), "match.yaml")
+	assert.NoError(ioutil.WriteFile(p, []byte("default:\n  match: \"*\"\n"), 0644))
+	fs, _ = newTestFlagSet()
+	fs.String("match", "", "")
+	assert.Error(ParseFlags(fs, []string{"--config", p}))
+
+	os.Setenv(EnvName("worker"), "x")
+	defer os.Unsetenv(EnvName("worker"))
+	fs, _ = newTestFlagSet()
+	assert.Error(ParseFlags(fs, []string{"--config", path}))
+}
+
+func TestParseFlagsWithoutConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	// ホームディレクトリの設定ファイルを読まないようにする
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", t.TempDir())
+	defer os.Setenv("HOME", home)
+
+	fs, tf := newTestFlagSet()
+	assert.NoError(ParseFlags(fs, []string{"--node", "10.0.0.1:6379"}))
+	assert.Equal(StrSlice{"10.0.0.1:6379"}, tf.conn.Nodes)
+	assert.Equal(uint(32), *tf.worker)
+
+	fs, _ = newTestFlagSet()
+	assert.Error(ParseFlags(fs, []string{"--profile", "prod-cache"}))
+}
+
+func TestParseFlagsEnvNotOverridable(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv(EnvName("match"), "user:*")
+	os.Setenv(EnvName("db"), "2")
+	defer os.Unsetenv(EnvName("match"))
+	defer os.Unsetenv(EnvName("db"))
+
+	fs, tf := newTestFlagSet()
+	match := fs.String("match", "", "")
+	assert.NoError(ParseFlags(fs, nil))
+	assert.Equal("", *match)
+	assert.Equal(2, tf.conn.DB)
+
+	// 環境変数で入れた値もVisitで見える
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "db" {
+			set = true
+		}
+	})
+	assert.True(set)
+}
+
+func TestParseFlagsNodeGroup(t *testing.T) {
+	assert := assert.New(t)
+	path := writeTestConfig(t)
+
+	nodeFile := filepath.Join(t.TempDir(), "nodes.txt")
+	assert.NoError(ioutil.WriteFile(nodeFile, []byte("10.0.0.5:6379\n10.0.0.6:6379\n"), 0644))
+
+	os.Setenv(EnvName("node"), "10.0.0.3:6379")
+	defer os.Unsetenv(EnvName("node"))
+
+	// --node-fileを指定したら環境変数と設定ファイルの--nodeは使わない
+	fs, tf := newTestFlagSet()
+	assert.NoError(ParseFlags(fs, []string{"--config", path, "--profile", "prod-cache", "--node-file", nodeFile}))
+	assert.Equal(StrSlice{"10.0.0.5:6379", "10.0.0.6:6379"}, tf.conn.Nodes)
+
+	// --nodeを指定したら環境変数の--node-fileも使わない
+	os.Setenv(EnvName("node-file"), nodeFile)
+	defer os.Unsetenv(EnvName("node-file"))
+	fs, tf = newTestFlagSet()
+	assert.NoError(ParseFlags(fs, []string{"--config", path, "--node", "10.0.0.1:6379"}))
+	assert.Equal(StrSlice{"10.0.0.1:6379"}, tf.conn.Nodes)
+
+	// 環境変数のNODEとNODE_FILEは両方使い、設定ファイルの--nodeは使わない
+	fs, tf = newTestFlagSet()
+	assert.NoError(ParseFlags(fs, []string{"--config", path, "--profile", "prod-cache"}))
+	assert.Equal(StrSlice{"10.0.0.3:6379", "10.0.0.5:6379", "10.0.0.6:6379"}, tf.conn.Nodes)
+}
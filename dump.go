@@ -0,0 +1,126 @@
+package redisutil
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// NoExpire 期限なしを表すexpireAtMsec
+const NoExpire = int64(-1)
+
+// FormatDumpLine DUMPの結果を{key}\t{expire unixtime msec or -1}\t{base64 of dump}の1行にする
+func FormatDumpLine(key string, expireAtMsec int64, dump string) string {
+	return key + "\t" + strconv.FormatInt(expireAtMsec, 10) + "\t" + base64.StdEncoding.EncodeToString([]byte(dump))
+}
+
+// ParseDumpLine FormatDumpLineの形式の行を解釈する
+func ParseDumpLine(line string) (string, int64, string, error) {
+	tokens := strings.SplitN(line, "\t", 3)
+	if len(tokens) != 3 {
+		return "", 0, "", fmt.Errorf("Number of tokens != 3")
+	}
+
+	expireAtMsec, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(tokens[2])
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	return tokens[0], expireAtMsec, string(b), nil
+}
+
+// キーのPTTLとサーバの現在時刻(msec)を同じノードで同時に取る。ARGV[1]がdumpならDUMPも返す
+// クライアント側の時刻を基準にすると、時計のずれや長時間の実行で期限の時刻がずれる
+const pttlWithTimeScript = `
+local t = redis.call('TIME')
+local pttl = redis.call('PTTL', KEYS[1])
+local reply = {pttl, tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)}
+if ARGV[1] == 'dump' and pttl ~= -2 then
+  reply[3] = redis.call('DUMP', KEYS[1])
+end
+return reply
+`
+
+var pttlWithTime = redis.NewScript(pttlWithTimeScript)
+
+// runPTTLWithTime {pttl, now[, dump]}を返す
+func runPTTLWithTime(ctx context.Context, client redis.UniversalClient, key string, withDump bool) ([]interface{}, error) {
+	arg := ""
+	if withDump {
+		arg = "dump"
+	}
+	v, err := pttlWithTime.Run(ctx, client, []string{key}, arg).Result()
+	if err != nil {
+		return nil, err
+	}
+	reply, ok := v.([]interface{})
+	if !ok || len(reply) < 2 {
+		return nil, fmt.Errorf("unexpected reply: %v", v)
+	}
+	if _, ok := reply[0].(int64); !ok {
+		return nil, fmt.Errorf("unexpected reply: %v", v)
+	}
+	if _, ok := reply[1].(int64); !ok {
+		return nil, fmt.Errorf("unexpected reply: %v", v)
+	}
+	return reply, nil
+}
+
+// PTTLWithServerTime PTTL(msec、-1/-2はそのまま)と、期限の時刻を求める基準となるサーバの現在時刻(unixtime msec)を返す
+func PTTLWithServerTime(ctx context.Context, client redis.UniversalClient, key string) (int64, int64, error) {
+	reply, err := runPTTLWithTime(ctx, client, key, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	return reply[0].(int64), reply[1].(int64), nil
+}
+
+// DumpKey キーをDUMPし、期限の時刻(unixtime msec、期限なしならNoExpire)と共に返す
+// 期限の時刻はサーバの時刻を基準にする。キーが存在しなければredis.Nilを返す
+func DumpKey(ctx context.Context, client redis.UniversalClient, key string) (int64, string, error) {
+	reply, err := runPTTLWithTime(ctx, client, key, true)
+	if err != nil {
+		return 0, "", err
+	}
+
+	pttl, now := reply[0].(int64), reply[1].(int64)
+	if pttl == -2 {
+		return 0, "", redis.Nil
+	}
+	if len(reply) != 3 {
+		return 0, "", fmt.Errorf("unexpected reply: %v", reply)
+	}
+	dump, ok := reply[2].(string)
+	if !ok {
+		return 0, "", fmt.Errorf("unexpected reply: %v", reply)
+	}
+
+	expireAtMsec := NoExpire
+	if pttl >= 0 {
+		expireAtMsec = now + pttl
+	}
+	return expireAtMsec, dump, nil
+}
+
+// RestoreKey DumpKeyの結果をRESTOREする。期限は絶対時刻(ABSTTL)で設定する
+func RestoreKey(ctx context.Context, client redis.UniversalClient, key string, expireAtMsec int64, dump string, replace bool) error {
+	args := []interface{}{"restore", key}
+	if expireAtMsec == NoExpire {
+		args = append(args, 0, dump)
+	} else {
+		args = append(args, expireAtMsec, dump, "absttl")
+	}
+	if replace {
+		args = append(args, "replace")
+	}
+	return client.Do(ctx, args...).Err()
+}
@@ -0,0 +1,34 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpLine(t *testing.T) {
+	assert := assert.New(t)
+
+	dump := "\x00\x03abc\t\n\x09\x00"
+	line := FormatDumpLine("key:1", 1600000000123, dump)
+	assert.NotContains(line[len("key:1\t1600000000123\t"):], "\t")
+
+	key, expireAtMsec, got, err := ParseDumpLine(line)
+	assert.NoError(err)
+	assert.Equal("key:1", key)
+	assert.Equal(int64(1600000000123), expireAtMsec)
+	assert.Equal(dump, got)
+
+	_, expireAtMsec, _, err = ParseDumpLine(FormatDumpLine("k", NoExpire, "x"))
+	assert.NoError(err)
+	assert.Equal(NoExpire, expireAtMsec)
+}
+
+func TestParseDumpLineError(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, line := range []string{"", "k\t1", "k\tx\tAAAA", "k\t1\t!!!"} {
+		_, _, _, err := ParseDumpLine(line)
+		assert.Error(err, line)
+	}
+}
@@ -0,0 +1,118 @@
+package redisutil
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration time.ParseDurationの書式に加えて日単位(ex. 7d, 1d12h)と、
+// 単位なしの整数(ミリ秒)を受け付ける
+func ParseDuration(s string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	var days time.Duration
+	if i := strings.Index(s, "d"); i >= 0 {
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		days = time.Duration(n * float64(24*time.Hour))
+		s = s[i+1:]
+		if s == "" {
+			return days, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return days + d, nil
+}
+
+// ParseExpireAt unixtime(ミリ秒)の整数、またはRFC3339形式の時刻を受け付ける
+func ParseExpireAt(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return UnixMsecToTime(ms), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// UnixMsecToTime unixtime(ミリ秒)をtime.Timeにする
+func UnixMsecToTime(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+// TimeToUnixMsec time.Timeをunixtime(ミリ秒)にする
+func TimeToUnixMsec(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// ErrLineTTLNotExist 入力行のTTL列が-2(pttlの出力でキーなし)だった
+var ErrLineTTLNotExist = errors.New("key did not exist")
+
+// ParseLineTTL 入力行のTTL列を解釈する
+// modeがttlなら相対時間(ParseDuration)、expireatなら期限の時刻(ParseExpireAt)として扱う
+// 空または-1(pttlの出力で期限なし)ならどちらもゼロ値を返す。-2ならErrLineTTLNotExistを返す
+// 期限なしで書き込まないよう、0以下の相対時間や1970年以前の時刻はエラーにする
+func ParseLineTTL(mode string, s string) (time.Duration, time.Time, error) {
+	if s == "" || s == "-1" {
+		return 0, time.Time{}, nil
+	}
+	if s == "-2" {
+		return 0, time.Time{}, ErrLineTTLNotExist
+	}
+	switch mode {
+	case "ttl":
+		d, err := ParseDuration(s)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if d <= 0 {
+			return 0, time.Time{}, fmt.Errorf("ttl must be > 0: %s", s)
+		}
+		return d, time.Time{}, nil
+	case "expireat":
+		tm, err := ParseExpireAt(s)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if TimeToUnixMsec(tm) <= 0 {
+			return 0, time.Time{}, fmt.Errorf("expire time must be after 1970: %s", s)
+		}
+		return 0, tm, nil
+	default:
+		return 0, time.Time{}, fmt.Errorf("unknown ttl mode: %s", mode)
+	}
+}
+
+// ParseFieldTTL JSONでフィールドごとに指定したTTLを解釈する。数値ならミリ秒、文字列ならParseDurationの書式
+// HPEXPIREは0以下だとフィールドを消してしまうので、0以下はエラーにする
+func ParseFieldTTL(v interface{}) (time.Duration, error) {
+	var d time.Duration
+	switch t := v.(type) {
+	case float64:
+		if t != math.Trunc(t) {
+			return 0, fmt.Errorf("invalid field ttl: %v", v)
+		}
+		d = time.Duration(t) * time.Millisecond
+	case string:
+		var err error
+		d, err = ParseDuration(t)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("invalid field ttl: %v", v)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("field ttl must be > 0: %v", v)
+	}
+	return d, nil
+}
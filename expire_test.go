@@ -0,0 +1,108 @@
+package redisutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"1500", 1500 * time.Millisecond},
+		{"90s", 90 * time.Second},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"0.5d", 12 * time.Hour},
+	}
+	for _, tt := range tests {
+		d, err := ParseDuration(tt.in)
+		assert.NoError(err, tt.in)
+		assert.Equal(tt.want, d, tt.in)
+	}
+
+	for _, in := range []string{"", "d", "xd", "7dx", "abc"} {
+		_, err := ParseDuration(in)
+		assert.Error(err, in)
+	}
+}
+
+func TestParseExpireAt(t *testing.T) {
+	assert := assert.New(t)
+
+	tm, err := ParseExpireAt("1600000000123")
+	assert.NoError(err)
+	assert.Equal(int64(1600000000123), TimeToUnixMsec(tm))
+
+	tm, err = ParseExpireAt("2020-09-13T12:26:40.123Z")
+	assert.NoError(err)
+	assert.Equal(int64(1600000000123), TimeToUnixMsec(tm))
+
+	_, err = ParseExpireAt("tomorrow")
+	assert.Error(err)
+}
+
+func TestParseLineTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	d, tm, err := ParseLineTTL("ttl", "7d")
+	assert.NoError(err)
+	assert.Equal(7*24*time.Hour, d)
+	assert.True(tm.IsZero())
+
+	d, tm, err = ParseLineTTL("expireat", "1600000000123")
+	assert.NoError(err)
+	assert.Equal(time.Duration(0), d)
+	assert.Equal(int64(1600000000123), TimeToUnixMsec(tm))
+
+	for _, s := range []string{"", "-1"} {
+		d, tm, err = ParseLineTTL("ttl", s)
+		assert.NoError(err)
+		assert.Equal(time.Duration(0), d)
+		assert.True(tm.IsZero())
+	}
+
+	// pttlの出力でキーなし
+	for _, mode := range []string{"ttl", "expireat"} {
+		_, _, err = ParseLineTTL(mode, "-2")
+		assert.Equal(ErrLineTTLNotExist, err, mode)
+	}
+
+	// 期限なしで書き込まないよう、0以下はエラー
+	for _, s := range []string{"0", "-3", "-1s", "0s"} {
+		_, _, err = ParseLineTTL("ttl", s)
+		assert.Error(err, s)
+	}
+	for _, s := range []string{"0", "-3"} {
+		_, _, err = ParseLineTTL("expireat", s)
+		assert.Error(err, s)
+	}
+
+	_, _, err = ParseLineTTL("ttl", "abc")
+	assert.Error(err)
+	_, _, err = ParseLineTTL("unknown", "1")
+	assert.Error(err)
+}
+
+func TestParseFieldTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := ParseFieldTTL(float64(1500))
+	assert.NoError(err)
+	assert.Equal(1500*time.Millisecond, d)
+
+	d, err = ParseFieldTTL("1h")
+	assert.NoError(err)
+	assert.Equal(time.Hour, d)
+
+	for _, v := range []interface{}{float64(0), float64(-2), float64(1.5), "0s", "-1", "abc", true, nil} {
+		_, err := ParseFieldTTL(v)
+		assert.Error(err, "%v", v)
+	}
+}
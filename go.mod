@@ -8,4 +8,5 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,51 @@
+package redisutil
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var conn Connection
+	conn.RegisterFlags(fs)
+	conn.RegisterReplicaFlag(fs)
+	err := fs.Parse([]string{"--node", "10.0.0.1:26379", "--node", "10.0.0.2:26379",
+		"--master-name", "mymaster", "--sentinel-password", "secret", "--db", "3", "--read-from-replica"})
+	assert.NoError(err)
+
+	assert.Equal(StrSlice{"10.0.0.1:26379", "10.0.0.2:26379"}, conn.Nodes)
+	assert.Equal(3, conn.DB)
+	assert.Equal("mymaster", conn.MasterName)
+	assert.Equal("secret", conn.SentinelPassword)
+	assert.True(conn.ReadFromReplica)
+	// Sentinelなら複数ノードでもDBを選べる
+	assert.False(conn.IsCluster())
+	assert.NoError(conn.Validate())
+}
+
+func TestConnectionPrefixedFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var src, dst Connection
+	src.RegisterPrefixedFlags(fs, "src-", "Source ")
+	dst.RegisterPrefixedFlags(fs, "dst-", "Destination ")
+	err := fs.Parse([]string{"--src-node", "a:6379", "--src-node", "b:6379", "--dst-node", "c:6379", "--dst-db", "1"})
+	assert.NoError(err)
+
+	assert.Equal(StrSlice{"a:6379", "b:6379"}, src.Nodes)
+	assert.True(src.IsCluster())
+	assert.NoError(src.Validate())
+	assert.Equal(1, dst.DB)
+	assert.NoError(dst.Validate())
+
+	src.DB = 1
+	assert.EqualError(src.Validate(), "--src-db can not be used with cluster")
+	dst.DB = -1
+	assert.EqualError(dst.Validate(), "--dst-db must be >= 0")
+}
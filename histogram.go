@@ -0,0 +1,84 @@
+package redisutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DurationHistogram 時間の長さをBoundsで区切った区間ごとに数える
+// Counts[i]はBounds[i-1] <= d < Bounds[i]の件数で、最後の要素はBoundsの最大値以上の件数
+type DurationHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+}
+
+func NewDurationHistogram(bounds []time.Duration) *DurationHistogram {
+	return &DurationHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *DurationHistogram) Add(d time.Duration) {
+	i := sort.Search(len(h.Bounds), func(i int) bool { return d < h.Bounds[i] })
+	h.Counts[i]++
+}
+
+func (h *DurationHistogram) Merge(o *DurationHistogram) {
+	for i := range o.Counts {
+		h.Counts[i] += o.Counts[i]
+	}
+}
+
+// Labels 区間を表すラベル(ex. <1m, 1m-1h, >=1h)
+func (h *DurationHistogram) Labels() []string {
+	ret := make([]string, 0, len(h.Counts))
+	for i := range h.Counts {
+		switch {
+		case len(h.Bounds) == 0:
+			ret = append(ret, "all")
+		case i == 0:
+			ret = append(ret, "<"+FormatDuration(h.Bounds[0]))
+		case i == len(h.Bounds):
+			ret = append(ret, ">="+FormatDuration(h.Bounds[i-1]))
+		default:
+			ret = append(ret, FormatDuration(h.Bounds[i-1])+"-"+FormatDuration(h.Bounds[i]))
+		}
+	}
+	return ret
+}
+
+// ParseDurationBounds カンマ区切りの時間(ParseDurationの書式)を昇順の区切りとして解釈する
+func ParseDurationBounds(s string) ([]time.Duration, error) {
+	var ret []time.Duration
+	for _, e := range strings.Split(s, ",") {
+		d, err := ParseDuration(strings.TrimSpace(e))
+		if err != nil {
+			return nil, err
+		}
+		if len(ret) > 0 && d <= ret[len(ret)-1] {
+			return nil, fmt.Errorf("bounds must be in ascending order: %s", s)
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// FormatDuration 日単位で割り切れる時間は7dのように、それ以外はtime.Durationの表記にする
+func FormatDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	s := d.String()
+	// 1h0m0s -> 1h
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
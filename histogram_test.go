@@ -0,0 +1,52 @@
+package redisutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewDurationHistogram([]time.Duration{time.Minute, time.Hour})
+	h.Add(0)
+	h.Add(59 * time.Second)
+	h.Add(time.Minute)
+	h.Add(time.Hour)
+	h.Add(48 * time.Hour)
+	assert.Equal([]uint64{2, 1, 2}, h.Counts)
+	assert.Equal([]string{"<1m", "1m-1h", ">=1h"}, h.Labels())
+
+	o := NewDurationHistogram([]time.Duration{time.Minute, time.Hour})
+	o.Add(time.Second)
+	h.Merge(o)
+	assert.Equal([]uint64{3, 1, 2}, h.Counts)
+
+	assert.Equal([]string{"all"}, NewDurationHistogram(nil).Labels())
+}
+
+func TestParseDurationBounds(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := ParseDurationBounds("1m, 1h,1d,30d")
+	assert.NoError(err)
+	assert.Equal([]time.Duration{time.Minute, time.Hour, 24 * time.Hour, 30 * 24 * time.Hour}, b)
+
+	_, err = ParseDurationBounds("1h,1m")
+	assert.Error(err)
+	_, err = ParseDurationBounds("1h,x")
+	assert.Error(err)
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("7d", FormatDuration(7*24*time.Hour))
+	assert.Equal("1h", FormatDuration(time.Hour))
+	assert.Equal("1m", FormatDuration(time.Minute))
+	assert.Equal("1h30m", FormatDuration(90*time.Minute))
+	assert.Equal("36h", FormatDuration(36*time.Hour))
+	assert.Equal("1.5s", FormatDuration(1500*time.Millisecond))
+}
@@ -0,0 +1,110 @@
+package redisutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StringifyJSONValue JSONをデコードした値をRedisに格納する文字列にする
+// 数値(json.Number)は元の表記のまま、オブジェクトや配列はJSON文字列にする
+func StringifyJSONValue(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case json.Number:
+		return t.String(), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case nil:
+		return "", nil
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// StringifyJSONValueAsArg UseNumberなしでデコードした値を、go-redisがコマンドの引数を文字列にするのと同じ表記にする
+// 真偽値は1/0、数値はstrconv.FormatFloat(f, 'f', -1, 64)。オブジェクトや配列はJSON文字列にする
+// hsetはmapをそのままHSETに渡していたので、格納される値が変わらないようこちらを使う
+func StringifyJSONValueAsArg(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case bool:
+		if t {
+			return "1", nil
+		}
+		return "0", nil
+	case json.Number:
+		return "", fmt.Errorf("decode json without UseNumber")
+	default:
+		return StringifyJSONValue(t)
+	}
+}
+
+// ParseOrderedJSONObject JSONオブジェクトをメンバの出現順を保ったまま{name, value, ...}の並びにする
+// 値はStringifyJSONValueで文字列にする
+func ParseOrderedJSONObject(s string) ([]string, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, fmt.Errorf("json object expected")
+	}
+
+	var kv []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("json object expected")
+		}
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		value, err := StringifyJSONValue(v)
+		if err != nil {
+			return nil, err
+		}
+		kv = append(kv, name, value)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return kv, nil
+}
+
+// FormatOrderedJSONObject {name, value, ...}の並びを、順序を保ったJSONオブジェクトにする
+func FormatOrderedJSONObject(kv []string) string {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(kv[i])
+		value, _ := json.Marshal(kv[i+1])
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
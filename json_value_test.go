@@ -0,0 +1,83 @@
+package redisutil
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringifyJSONValue(t *testing.T) {
+	assert := assert.New(t)
+
+	var m map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(`{"s":"abc","n":1.50,"b":true,"z":null,"o":{"x":1},"a":[1,"2"]}`))
+	dec.UseNumber()
+	assert.NoError(dec.Decode(&m))
+
+	want := map[string]string{
+		"s": "abc",
+		"n": "1.50",
+		"b": "true",
+		"z": "",
+		"o": `{"x":1}`,
+		"a": `[1,"2"]`,
+	}
+	for k, v := range want {
+		s, err := StringifyJSONValue(m[k])
+		assert.NoError(err, k)
+		assert.Equal(v, s, k)
+	}
+}
+
+func TestStringifyJSONValueAsArg(t *testing.T) {
+	assert := assert.New(t)
+
+	var m map[string]interface{}
+	assert.NoError(json.Unmarshal([]byte(`{"s":"abc","n":1.50,"i":12345678,"e":1e21,"t":true,"f":false,"z":null,"o":{"x":1}}`), &m))
+
+	want := map[string]string{
+		"s": "abc",
+		"n": "1.5",
+		"i": "12345678",
+		"e": "1000000000000000000000",
+		"t": "1",
+		"f": "0",
+		"z": "",
+		"o": `{"x":1}`,
+	}
+	for k, v := range want {
+		s, err := StringifyJSONValueAsArg(m[k])
+		assert.NoError(err, k)
+		assert.Equal(v, s, k)
+	}
+}
+
+func TestParseOrderedJSONObject(t *testing.T) {
+	assert := assert.New(t)
+
+	kv, err := ParseOrderedJSONObject(`{"z":"1","a":2,"m":{"k":[true]}}`)
+	assert.NoError(err)
+	assert.Equal([]string{"z", "1", "a", "2", "m", `{"k":[true]}`}, kv)
+
+	kv, err = ParseOrderedJSONObject(`{}`)
+	assert.NoError(err)
+	assert.Empty(kv)
+
+	for _, s := range []string{``, `[]`, `"a"`, `{"a":}`, `{"a":1`} {
+		_, err := ParseOrderedJSONObject(s)
+		assert.Error(err, s)
+	}
+}
+
+func TestFormatOrderedJSONObject(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`{}`, FormatOrderedJSONObject(nil))
+	assert.Equal(`{"z":"1","a":"x\"y"}`, FormatOrderedJSONObject([]string{"z", "1", "a", `x"y`}))
+
+	kv, err := ParseOrderedJSONObject(FormatOrderedJSONObject([]string{"b", "2", "a", "1"}))
+	assert.NoError(err)
+	assert.Equal([]string{"b", "2", "a", "1"}, kv)
+}
@@ -0,0 +1,23 @@
+package redisutil
+
+import (
+	"strings"
+)
+
+// KeyPrefix キーをdelimiterで区切った先頭depth個の要素をプレフィックスとして返す
+// 要素がdepth個に満たないキーはキー全体を返す
+func KeyPrefix(key string, delimiter string, depth int) string {
+	if delimiter == "" || depth <= 0 {
+		return key
+	}
+
+	pos := 0
+	for i := 0; i < depth; i++ {
+		n := strings.Index(key[pos:], delimiter)
+		if n < 0 {
+			return key
+		}
+		pos += n + len(delimiter)
+	}
+	return key[:pos-len(delimiter)]
+}
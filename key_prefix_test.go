@@ -0,0 +1,21 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("user", KeyPrefix("user:1:name", ":", 1))
+	assert.Equal("user:1", KeyPrefix("user:1:name", ":", 2))
+	assert.Equal("user:1:name", KeyPrefix("user:1:name", ":", 3))
+	assert.Equal("user:1:name", KeyPrefix("user:1:name", ":", 5))
+	assert.Equal("a", KeyPrefix("a::b", "::", 1))
+	assert.Equal("nodelim", KeyPrefix("nodelim", ":", 1))
+	assert.Equal("", KeyPrefix(":x", ":", 1))
+	assert.Equal("user:1", KeyPrefix("user:1", "", 1))
+	assert.Equal("user:1", KeyPrefix("user:1", ":", 0))
+}
@@ -0,0 +1,65 @@
+package redisutil
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeyRewriter キー名をプレフィックスの置き換えか正規表現で書き換える
+type KeyRewriter struct {
+	fromPrefix string
+	toPrefix   string
+	re         *regexp.Regexp
+	repl       string
+}
+
+// NewPrefixRewriter fromPrefixで始まるキーのプレフィックスをtoPrefixに置き換える
+func NewPrefixRewriter(fromPrefix string, toPrefix string) (*KeyRewriter, error) {
+	if fromPrefix == "" {
+		return nil, fmt.Errorf("Prefix to be replaced must not be empty")
+	}
+	return &KeyRewriter{fromPrefix: fromPrefix, toPrefix: toPrefix}, nil
+}
+
+// NewRegexpRewriter exprに一致するキーをreplに書き換える。replでは$1等で部分一致を参照できる
+func NewRegexpRewriter(expr string, repl string) (*KeyRewriter, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyRewriter{re: re, repl: repl}, nil
+}
+
+// Rewrite 書き換え後のキーを返す。規則に一致しなければfalseを返す
+func (r *KeyRewriter) Rewrite(key string) (string, bool) {
+	if r.re != nil {
+		m := r.re.FindStringSubmatchIndex(key)
+		if m == nil {
+			return "", false
+		}
+		// 一致しなかった部分は残す
+		dst := r.re.ExpandString(nil, r.repl, key, m)
+		return key[:m[0]] + string(dst) + key[m[1]:], true
+	}
+
+	if !strings.HasPrefix(key, r.fromPrefix) {
+		return "", false
+	}
+	return r.toPrefix + key[len(r.fromPrefix):], true
+}
+
+// Stable 書き換え後のキーdstが再び規則に一致しなければtrue
+// 一致するキーは、SCANしながら書き換えたり再実行したりすると何度も書き換えられてしまう
+func (r *KeyRewriter) Stable(dst string) bool {
+	_, ok := r.Rewrite(dst)
+	return !ok
+}
+
+// ScanPattern 書き換えの対象になりうるキーをSCANするためのMATCHパターン。正規表現の規則では空
+func (r *KeyRewriter) ScanPattern() string {
+	if r.re != nil {
+		return ""
+	}
+	return EscapePattern(r.fromPrefix) + "*"
+}
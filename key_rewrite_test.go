@@ -0,0 +1,78 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixRewriter(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := NewPrefixRewriter("old:", "new:")
+	assert.NoError(err)
+
+	dst, ok := r.Rewrite("old:user:1")
+	assert.True(ok)
+	assert.Equal("new:user:1", dst)
+
+	_, ok = r.Rewrite("other:old:user:1")
+	assert.False(ok)
+
+	assert.Equal("old:*", r.ScanPattern())
+
+	_, err = NewPrefixRewriter("", "new:")
+	assert.Error(err)
+}
+
+func TestRegexpRewriter(t *testing.T) {
+	assert := assert.New(t)
+
+	// クラスタ向けにハッシュタグ形式へ移す
+	r, err := NewRegexpRewriter(`^user:(\d+):(\w+)$`, "user:{$1}:$2")
+	assert.NoError(err)
+
+	dst, ok := r.Rewrite("user:1000:profile")
+	assert.True(ok)
+	assert.Equal("user:{1000}:profile", dst)
+
+	_, ok = r.Rewrite("user:abc:profile")
+	assert.False(ok)
+
+	// 一致しなかった部分は残る
+	r, err = NewRegexpRewriter(`v1`, "v2")
+	assert.NoError(err)
+	dst, ok = r.Rewrite("app:v1:key")
+	assert.True(ok)
+	assert.Equal("app:v2:key", dst)
+
+	assert.Equal("", r.ScanPattern())
+
+	_, err = NewRegexpRewriter(`(`, "x")
+	assert.Error(err)
+}
+
+func TestKeyRewriterStable(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := NewPrefixRewriter("old:", "new:")
+	assert.NoError(err)
+	dst, _ := r.Rewrite("old:1")
+	assert.True(r.Stable(dst))
+
+	// 書き換え後のキーも--from-prefixで始まる
+	r, err = NewPrefixRewriter("user:", "user:v2:")
+	assert.NoError(err)
+	dst, _ = r.Rewrite("user:1")
+	assert.False(r.Stable(dst))
+
+	r, err = NewRegexpRewriter(`^user:(\d+):(\w+)$`, "user:{$1}:$2")
+	assert.NoError(err)
+	dst, _ = r.Rewrite("user:1000:profile")
+	assert.True(r.Stable(dst))
+
+	r, err = NewRegexpRewriter(`^user:(.*)$`, "user:{$1}")
+	assert.NoError(err)
+	dst, _ = r.Rewrite("user:1")
+	assert.False(r.Stable(dst))
+}
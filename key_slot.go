@@ -0,0 +1,33 @@
+package redisutil
+
+import "strings"
+
+// ClusterSlots Redis Clusterのスロット数
+const ClusterSlots = 16384
+
+// KeySlot Redis Clusterでkeyが属するスロットを返す
+// {}で囲まれたハッシュタグがあれば、その部分だけで計算する
+func KeySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) % ClusterSlots)
+}
+
+// crc16 CRC16-CCITT(XMODEM)
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,23 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySlot(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint16(0x31C3), crc16("123456789"))
+
+	// CLUSTER KEYSLOTの結果
+	assert.Equal(12182, KeySlot("foo"))
+	assert.Equal(5061, KeySlot("bar"))
+	assert.Equal(KeySlot("user1000"), KeySlot("{user1000}.following"))
+	assert.Equal(KeySlot("{user1000}.followers"), KeySlot("{user1000}.following"))
+	// 空の{}はハッシュタグとして扱わない
+	assert.Equal(KeySlot("foo{}{bar}"), int(crc16("foo{}{bar}")%ClusterSlots))
+	// 最初の{}だけを見る
+	assert.Equal(KeySlot("bar"), KeySlot("foo{bar}{zap}"))
+}
@@ -0,0 +1,110 @@
+package redisutil
+
+import "strings"
+
+// MatchPattern Redisのglob形式のパターン(KEYSやSCAN MATCHと同じ)にkeyが一致するか
+// *, ?, [abc], [^abc], [a-z]と\によるエスケープに対応する
+func MatchPattern(pattern string, key string) bool {
+	p, k := 0, 0
+	// 直前の*の位置と、そのときのkeyの位置。不一致ならここからやり直す
+	starP, starK := -1, 0
+	for k < len(key) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starK = p, k
+				p++
+				continue
+			case '?':
+				p++
+				k++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern, p, key[k]); ok {
+					p = end
+					k++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == key[k] {
+					p += 2
+					k++
+					continue
+				}
+			default:
+				if pattern[p] == key[k] {
+					p++
+					k++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starK++
+		p, k = starP+1, starK
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass pattern[p]から始まる[...]にcが含まれるか。含まれれば]の次の位置を返す
+func matchClass(pattern string, p int, c byte) (int, bool) {
+	p++
+	not := false
+	if p < len(pattern) && pattern[p] == '^' {
+		not = true
+		p++
+	}
+
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+			p++
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= c && c <= hi {
+				matched = true
+			}
+			p += 3
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+			p++
+		}
+	}
+	if p >= len(pattern) {
+		// 閉じていない[は不一致扱い
+		return 0, false
+	}
+	if not {
+		matched = !matched
+	}
+	return p + 1, matched
+}
+
+// EscapePattern sをMatchPatternやSCAN MATCHのパターンとして文字通りに扱われるようにする
+func EscapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchPattern(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "anything/with:sep", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users:1", false},
+		{"user:*:name", "user:1:2:name", true},
+		{"user:*:name", "user:1:names", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hallo", false},
+		{"h[ello", "hello", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.want, MatchPattern(tt.pattern, tt.key), "%s %s", tt.pattern, tt.key)
+	}
+}
+
+func TestEscapePattern(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`user\*\?\[1\]\\:`, EscapePattern(`user*?[1]\:`))
+	for _, s := range []string{"plain:key", `a*b?c[d]e\f`} {
+		assert.True(MatchPattern(EscapePattern(s), s), s)
+		assert.True(MatchPattern(EscapePattern(s)+"*", s+":suffix"), s)
+	}
+	assert.False(MatchPattern(EscapePattern("a*"), "abc"))
+}
@@ -0,0 +1,42 @@
+package redisutil
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter Waitの呼び出しを毎秒PerSec回までに抑える。複数のgoroutineから使える
+type RateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// NewRateLimiter perSec <= 0なら制限しない
+func NewRateLimiter(perSec float64) *RateLimiter {
+	r := &RateLimiter{}
+	if perSec > 0 {
+		r.interval = time.Duration(float64(time.Second) / perSec)
+	}
+	return r
+}
+
+// Wait 前回の呼び出しから間隔が空くまで待つ
+func (r *RateLimiter) Wait() {
+	if r.interval <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,31 @@
+package redisutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRateLimiter(100)
+	from := time.Now()
+	for i := 0; i < 11; i++ {
+		r.Wait()
+	}
+	// 1回目は待たないので10間隔分
+	assert.GreaterOrEqual(int64(time.Since(from)), int64(100*time.Millisecond))
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRateLimiter(0)
+	from := time.Now()
+	for i := 0; i < 10000; i++ {
+		r.Wait()
+	}
+	assert.Less(int64(time.Since(from)), int64(100*time.Millisecond))
+}
@@ -0,0 +1,51 @@
+package redisutil
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RenameKey srcをdstにリネームする。期限は引き継ぐ
+// nxならdstが既にあるときは何もせずfalseを返す。srcがなければredis.Nilを返す
+// クラスタでsrcとdstのスロットが異なる場合はRENAMEできないので、DUMP/RESTOREしてからsrcを消す
+// この場合は不可分ではないので、途中で失敗するとsrcとdstの両方が残ることがある
+func RenameKey(ctx context.Context, client redis.UniversalClient, src string, dst string, nx bool) (bool, error) {
+	if _, ok := client.(*redis.ClusterClient); ok && KeySlot(src) != KeySlot(dst) {
+		return moveKey(ctx, client, src, dst, nx)
+	}
+
+	var renamed bool
+	var err error
+	if nx {
+		renamed, err = client.RenameNX(ctx, src, dst).Result()
+	} else {
+		err = client.Rename(ctx, src, dst).Err()
+		renamed = err == nil
+	}
+	if err != nil && strings.Contains(err.Error(), "no such key") {
+		return false, redis.Nil
+	}
+	return renamed, err
+}
+
+func moveKey(ctx context.Context, client redis.UniversalClient, src string, dst string, nx bool) (bool, error) {
+	expireAtMsec, dump, err := DumpKey(ctx, client, src)
+	if err != nil {
+		return false, err
+	}
+
+	err = RestoreKey(ctx, client, dst, expireAtMsec, dump, !nx)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "BUSYKEY") {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := client.Del(ctx, src).Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -4,6 +4,8 @@ type Result struct {
 	Lines    uint64
 	BadCount uint64
 	Errors   map[string]uint64
+	// エラー以外の集計値(コマンド固有の件数など)
+	Counts map[string]uint64
 }
 
 func (r *Result) AddError(emes string) {
@@ -11,22 +13,32 @@ func (r *Result) AddError(emes string) {
 	r.Errors[emes]++
 }
 
+func (r *Result) AddCount(name string, n uint64) {
+	r.Counts[name] += n
+}
+
 func (r *Result) Combine(o Result) Result {
 	ret := Result{
 		Lines:    r.Lines + o.Lines,
 		BadCount: r.BadCount + o.BadCount,
 		Errors:   r.Errors,
+		Counts:   r.Counts,
 	}
 
 	for k := range o.Errors {
 		r.Errors[k] += o.Errors[k]
 	}
 
+	for k := range o.Counts {
+		r.Counts[k] += o.Counts[k]
+	}
+
 	return ret
 }
 
 func NewResult() Result {
 	return Result{
 		Errors: map[string]uint64{},
+		Counts: map[string]uint64{},
 	}
 }
@@ -0,0 +1,32 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCombine(t *testing.T) {
+	assert := assert.New(t)
+
+	r1 := NewResult()
+	r1.Lines = 3
+	r1.AddError("err1")
+	r1.AddCount("added", 2)
+
+	r2 := NewResult()
+	r2.Lines = 5
+	r2.AddError("err1")
+	r2.AddError("err2")
+	r2.AddCount("added", 1)
+	r2.AddCount("updated", 4)
+
+	total := NewResult()
+	total = total.Combine(r1)
+	total = total.Combine(r2)
+
+	assert.Equal(uint64(8), total.Lines)
+	assert.Equal(uint64(3), total.BadCount)
+	assert.Equal(map[string]uint64{"err1": 2, "err2": 1}, total.Errors)
+	assert.Equal(map[string]uint64{"added": 3, "updated": 4}, total.Counts)
+}
@@ -0,0 +1,87 @@
+package redisutil
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var errEnoughSamples = errors.New("enough samples")
+
+// SampleRandomKeys RANDOMKEYでn個のキーを拾ってchLineに送る。同じキーが重複することがある
+func SampleRandomKeys(ctx context.Context, client redis.UniversalClient, n uint64, chLine chan<- string) (int64, error) {
+	const batch = 100
+	var lc int64
+	for remain := n; remain > 0; {
+		size := uint64(batch)
+		if remain < size {
+			size = remain
+		}
+
+		pipe := client.Pipeline()
+		cmds := make([]*redis.StringCmd, 0, size)
+		for i := uint64(0); i < size; i++ {
+			cmds = append(cmds, pipe.RandomKey(ctx))
+		}
+		// 個別のエラーは各cmdで見る
+		_, _ = pipe.Exec(ctx)
+
+		for _, cmd := range cmds {
+			key, err := cmd.Result()
+			if err == redis.Nil {
+				return lc, errors.New("database is empty")
+			} else if err != nil {
+				return lc, err
+			}
+			lc++
+			chLine <- key
+		}
+		remain -= size
+	}
+	return lc, nil
+}
+
+// SampleScanKeys 全マスタをSCANしたキーのうちrateの割合をchLineに送る。n > 0ならn件送った時点で止める
+// n件送った後にまだキーが残っていてSCANを途中で止めたときはtruncatedがtrue
+func SampleScanKeys(ctx context.Context, client redis.UniversalClient, args ScanArgs, rate float64, n uint64, chLine chan<- string) (int64, bool, error) {
+	var lc int64
+	mu := &sync.Mutex{}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	err := ScanAll(ctx, client, args, func(key string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if n > 0 && uint64(lc) >= n {
+			return errEnoughSamples
+		}
+		if rate < 1 && r.Float64() >= rate {
+			return nil
+		}
+		lc++
+		chLine <- key
+		return nil
+	})
+	if err == errEnoughSamples {
+		return lc, true, nil
+	}
+	return lc, false, err
+}
+
+// TotalDBSize 全マスタのDBSIZEの合計
+func TotalDBSize(ctx context.Context, client redis.UniversalClient) (int64, error) {
+	var total int64
+	err := ForEachMasterNode(ctx, client, func(ctx context.Context, node redis.UniversalClient) error {
+		n, err := node.DBSize(ctx).Result()
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&total, n)
+		return nil
+	})
+	return total, err
+}
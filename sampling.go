@@ -0,0 +1,62 @@
+package redisutil
+
+import (
+	"math"
+)
+
+// binomialCDF 二項分布B(n, p)でX <= kとなる確率
+func binomialCDF(n, k uint64, p float64) float64 {
+	if k >= n || p <= 0 {
+		return 1
+	}
+	if p >= 1 {
+		return 0
+	}
+
+	lnN, _ := math.Lgamma(float64(n) + 1)
+	lp := math.Log(p)
+	lq := math.Log1p(-p)
+	sum := 0.0
+	for i := uint64(0); i <= k; i++ {
+		lnI, _ := math.Lgamma(float64(i) + 1)
+		lnNI, _ := math.Lgamma(float64(n-i) + 1)
+		sum += math.Exp(lnN - lnI - lnNI + float64(i)*lp + float64(n-i)*lq)
+	}
+	if sum > 1 {
+		return 1
+	}
+	return sum
+}
+
+// MismatchUpperBound n件のサンプル中k件が不一致だったとき、
+// 母集団の不一致率の片側信頼上限(Clopper-Pearson)を返す
+func MismatchUpperBound(n, k uint64, confidence float64) float64 {
+	if n == 0 || k >= n {
+		return 1
+	}
+	alpha := 1 - confidence
+	if k == 0 {
+		return 1 - math.Pow(alpha, 1/float64(n))
+	}
+
+	// P(X <= k | p)はpについて単調減少なので二分法で求める
+	lo, hi := float64(k)/float64(n), 1.0
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if binomialCDF(n, k, mid) > alpha {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi
+}
+
+// ConsistencyConfidence n件のサンプル中k件が不一致だったとき、
+// 母集団の不一致率がtolerance未満であるといえる信頼度を返す
+func ConsistencyConfidence(n, k uint64, tolerance float64) float64 {
+	if n == 0 {
+		return 0
+	}
+	return 1 - binomialCDF(n, k, tolerance)
+}
@@ -0,0 +1,45 @@
+package redisutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMismatchUpperBoundNoMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	// いわゆる「3の法則」: 95%信頼上限はおよそ3/n
+	assert.InDelta(3.0/3000, MismatchUpperBound(3000, 0, 0.95), 0.00001)
+	assert.Equal(1.0, MismatchUpperBound(0, 0, 0.95))
+}
+
+func TestMismatchUpperBoundWithMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	ub := MismatchUpperBound(1000, 5, 0.95)
+	assert.True(ub > 5.0/1000)
+	// 上限の位置ではP(X <= k) = 1 - confidence
+	assert.InDelta(0.05, binomialCDF(1000, 5, ub), 0.0001)
+
+	assert.True(MismatchUpperBound(1000, 10, 0.95) > ub)
+	assert.Equal(1.0, MismatchUpperBound(10, 10, 0.95))
+}
+
+func TestConsistencyConfidence(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.InDelta(0.95, ConsistencyConfidence(2995, 0, 0.001), 0.001)
+	assert.True(ConsistencyConfidence(2995, 3, 0.001) < 0.95)
+	assert.Equal(0.0, ConsistencyConfidence(0, 0, 0.001))
+}
+
+func TestBinomialCDF(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.InDelta(0.5, binomialCDF(1, 0, 0.5), 1e-9)
+	assert.InDelta(0.75, binomialCDF(2, 1, 0.5), 1e-9)
+	assert.Equal(1.0, binomialCDF(2, 2, 0.5))
+	assert.Equal(1.0, binomialCDF(10, 0, 0))
+	assert.Equal(0.0, binomialCDF(10, 3, 1))
+}
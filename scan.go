@@ -0,0 +1,184 @@
+package redisutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ScanArgs SCANの条件
+type ScanArgs struct {
+	Match string
+	Count int64
+	// 空でなければSCAN ... TYPEで絞り込む
+	Type string
+	// nilでなければノードごとのカーソルを記録し、記録があれば続きからSCANする
+	State *ScanState
+}
+
+// ForEachMasterNode クラスタ構成なら全マスタ、そうでなければクライアント自身に対してfnを呼ぶ
+// クラスタの場合fnはマスタごとに並行して呼ばれる
+func ForEachMasterNode(ctx context.Context, client redis.UniversalClient,
+	fn func(ctx context.Context, node redis.UniversalClient) error) error {
+	if cc, ok := client.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return fn(ctx, c)
+		})
+	}
+	return fn(ctx, client)
+}
+
+// ScanAll 全マスタをSCANして見つかったキーをfnに渡す
+// fnがerrorを返したらそのノードのSCANを中断する。fnは並行して呼ばれることがある
+func ScanAll(ctx context.Context, client redis.UniversalClient, args ScanArgs, fn func(key string) error) error {
+	return ScanBatches(ctx, client, args, func(keys []string) error {
+		for _, k := range keys {
+			if err := fn(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ScanBatches 全マスタをSCANして、1回のSCANで見つかったキーをまとめてfnに渡す
+// Stateのカーソルはfnが返ってから保存するので、fnの中でキーの処理を終えておけば再開しても取りこぼさない
+// fnがerrorを返したらそのノードのSCANを中断する。fnはマスタごとに並行して呼ばれることがある
+func ScanBatches(ctx context.Context, client redis.UniversalClient, args ScanArgs, fn func(keys []string) error) error {
+	return ScanNodeBatches(ctx, client, args, func(addr string, keys []string, cursor uint64) error {
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if args.State != nil {
+			return args.State.Set(addr, cursor)
+		}
+		return nil
+	})
+}
+
+// ScanNodeBatches ScanBatchesと同じだが、キーがなくてもSCANのたびにノードのアドレスと次のカーソルを添えてfnを呼ぶ
+// Stateは再開するカーソルを得るのにだけ使い、保存はfnで行う。途中までの集計をカーソルと一緒に保存する場合に使う
+func ScanNodeBatches(ctx context.Context, client redis.UniversalClient, args ScanArgs,
+	fn func(addr string, keys []string, cursor uint64) error) error {
+	return ForEachMasterNode(ctx, client, func(ctx context.Context, node redis.UniversalClient) error {
+		var cursor uint64
+		addr := nodeAddr(node)
+		if args.State != nil {
+			var done bool
+			cursor, done = args.State.Get(addr)
+			if done {
+				return nil
+			}
+		}
+		for {
+			var keys []string
+			var err error
+			if args.Type != "" {
+				keys, cursor, err = node.ScanType(ctx, cursor, args.Match, args.Count, args.Type).Result()
+			} else {
+				keys, cursor, err = node.Scan(ctx, cursor, args.Match, args.Count).Result()
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(addr, keys, cursor); err != nil {
+				return err
+			}
+			if cursor == 0 {
+				return nil
+			}
+		}
+	})
+}
+
+// nodeAddr ScanStateでノードを区別するためのアドレス
+func nodeAddr(node redis.UniversalClient) string {
+	if c, ok := node.(*redis.Client); ok {
+		return c.Options().Addr
+	}
+	return ""
+}
+
+// ScanState ノードごとのSCANのカーソル。中断したSCANを続きから再開するためにファイルに保存する
+// 再開すると、中断したときに処理中だったSCAN1回分のキーを再び渡す
+// クラスタの構成が変わるとカーソルの意味が変わるので、再開できない
+type ScanState struct {
+	path string
+	mu   sync.Mutex
+	// ノードのアドレスごとの、次にSCANするカーソル
+	Cursors map[string]uint64 `json:"cursors"`
+	// 最後までSCANしたノード
+	Done map[string]bool `json:"done"`
+	// カーソルと一緒に保存する、利用側の値(途中までの集計など)
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// LoadScanState pathから読む。ファイルがなければ最初からSCANする状態を返す
+func LoadScanState(path string) (*ScanState, error) {
+	s := &ScanState{path: path, Cursors: map[string]uint64{}, Done: map[string]bool{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if s.Cursors == nil {
+		s.Cursors = map[string]uint64{}
+	}
+	if s.Done == nil {
+		s.Done = map[string]bool{}
+	}
+	return s, nil
+}
+
+// Get ノードの次のカーソルと、最後までSCANしたかを返す
+func (s *ScanState) Get(addr string) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Cursors[addr], s.Done[addr]
+}
+
+// Set ノードの次のカーソルを記録してファイルに保存する。cursorが0なら最後までSCANした
+func (s *ScanState) Set(addr string, cursor uint64) error {
+	return s.SetWithData(addr, cursor, nil)
+}
+
+// SetWithData Setと同じだが、dataがnilでなければDataも置き換えて同じファイルに保存する
+func (s *ScanState) SetWithData(addr string, cursor uint64, data interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		s.Data = b
+	}
+	if cursor == 0 {
+		delete(s.Cursors, addr)
+		s.Done[addr] = true
+	} else {
+		s.Cursors[addr] = cursor
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// 書きかけのファイルが残らないよう、別名で書いてから置き換える
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
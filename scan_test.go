@@ -0,0 +1,65 @@
+package redisutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanState(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "scan.json")
+	s, err := LoadScanState(path)
+	assert.NoError(err)
+
+	cursor, done := s.Get("10.0.0.1:6379")
+	assert.Equal(uint64(0), cursor)
+	assert.False(done)
+
+	assert.NoError(s.Set("10.0.0.1:6379", 1234))
+	assert.NoError(s.Set("10.0.0.2:6379", 99))
+	assert.NoError(s.Set("10.0.0.2:6379", 0))
+
+	s, err = LoadScanState(path)
+	assert.NoError(err)
+	cursor, done = s.Get("10.0.0.1:6379")
+	assert.Equal(uint64(1234), cursor)
+	assert.False(done)
+	cursor, done = s.Get("10.0.0.2:6379")
+	assert.Equal(uint64(0), cursor)
+	assert.True(done)
+}
+
+func TestScanStateData(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "scan.json")
+	s, err := LoadScanState(path)
+	assert.NoError(err)
+	assert.Nil(s.Data)
+
+	assert.NoError(s.SetWithData("10.0.0.1:6379", 10, map[string]int{"keys": 3}))
+	// nilなら前の値のまま
+	assert.NoError(s.Set("10.0.0.2:6379", 20))
+
+	s, err = LoadScanState(path)
+	assert.NoError(err)
+	cursor, _ := s.Get("10.0.0.1:6379")
+	assert.Equal(uint64(10), cursor)
+	var data map[string]int
+	assert.NoError(json.Unmarshal(s.Data, &data))
+	assert.Equal(map[string]int{"keys": 3}, data)
+}
+
+func TestLoadScanStateError(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "scan.json")
+	assert.NoError(ioutil.WriteFile(path, []byte("{"), 0644))
+	_, err := LoadScanState(path)
+	assert.Error(err)
+}
@@ -1,6 +1,8 @@
 package redisutil
 
 import (
+	"bufio"
+	"compress/gzip"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -46,3 +48,66 @@ func StartWriters(outSplit uint, out string, compress string, chLine <-chan stri
 
 	return wgOut
 }
+
+// SyncWriter 書き込みのたびにファイルまでSyncする。書けたことを確かめてから次の処理をしたい場合に使う
+type SyncWriter struct {
+	f   *os.File
+	gzw *gzip.Writer
+	w   *bufio.Writer
+}
+
+// CreateSyncWriter compressに応じた拡張子を付けてファイルを作る
+func CreateSyncWriter(fn string, compress string) (*SyncWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(fn), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	ct := GetCompressionType(compress)
+	f, err := os.Create(fn + ct.Ext)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SyncWriter{f: f}
+	if ct.Type == CompressionGzip {
+		s.gzw = gzip.NewWriter(f)
+		s.w = bufio.NewWriter(s.gzw)
+	} else {
+		s.w = bufio.NewWriter(f)
+	}
+	return s, nil
+}
+
+// WriteLines 各行を書いてSyncする。エラーを返した場合、どこまで書けたかはわからない
+func (s *SyncWriter) WriteLines(lines []string) error {
+	for _, line := range lines {
+		if _, err := s.w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.gzw != nil {
+		if err := s.gzw.Flush(); err != nil {
+			return err
+		}
+	}
+	return s.f.Sync()
+}
+
+func (s *SyncWriter) Close() error {
+	err := s.w.Flush()
+	if s.gzw != nil {
+		if e := s.gzw.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := s.f.Sync(); err == nil {
+		err = e
+	}
+	if e := s.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package redisutil
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	fn := filepath.Join(t.TempDir(), "sub", "backup-000")
+	w, err := CreateSyncWriter(fn, "none")
+	assert.NoError(err)
+
+	assert.NoError(w.WriteLines([]string{"a", "b"}))
+	// Closeしなくても書けている
+	b, err := ioutil.ReadFile(fn)
+	assert.NoError(err)
+	assert.Equal("a\nb\n", string(b))
+
+	assert.NoError(w.WriteLines([]string{"c"}))
+	assert.NoError(w.Close())
+	b, err = ioutil.ReadFile(fn)
+	assert.NoError(err)
+	assert.Equal("a\nb\nc\n", string(b))
+}
+
+func TestSyncWriterGzip(t *testing.T) {
+	assert := assert.New(t)
+
+	fn := filepath.Join(t.TempDir(), "backup-000")
+	w, err := CreateSyncWriter(fn, "gzip")
+	assert.NoError(err)
+	assert.NoError(w.WriteLines([]string{"a", "b"}))
+
+	// Closeする前でも、書いた分は読める
+	f, err := os.Open(fn + ".gz")
+	assert.NoError(err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	assert.NoError(err)
+	buf := make([]byte, 4)
+	n, err := r.Read(buf)
+	assert.NoError(err)
+	assert.Equal("a\nb\n", string(buf[:n]))
+
+	assert.NoError(w.Close())
+}